@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/WICG/webpackage/go/bundle"
+	"github.com/horo-t/sub-sxg/subsxg"
+)
+
+// runBundle converts a Web Bundle to the signed exchanges of a scenario, and
+// a scenario back to a Web Bundle:
+//
+//	sub-sxg bundle unpack [-name <scenario>] [-host <host>] <in.wbn> <dir>
+//	sub-sxg bundle pack <scenario> <out.wbn>
+//
+// The scenarios of the bundles in IMPORT_BUNDLES can be packed too. Packing
+// drops the allowed-alt-sxg links, which the unsigned responses can't meet.
+func runBundle(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "unpack":
+			return runBundleUnpack(args[1:])
+		case "pack":
+			return runBundlePack(args[1:])
+		}
+	}
+	fmt.Fprintln(os.Stderr, "Usage: sub-sxg bundle unpack|pack [flags] <args>")
+	return 2
+}
+
+func runBundleUnpack(args []string) int {
+	flags := flag.NewFlagSet("bundle unpack", flag.ExitOnError)
+	name := flags.String("name", "bundle.sxg", "the name of the parent scenario")
+	host := flags.String("host", "", "the host which serves the exchanges at /sxg/ (default the demo domain)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sub-sxg bundle unpack [flags] <in.wbn> <dir>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	b, err := readBundleFile(flags.Arg(0))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	server, err := newServer(subsxg.WithBundle(b, subsxg.BundleOptions{Name: *name}))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if *host == "" {
+		*host = server.DemoDomainName()
+	}
+	files, err := server.WriteScenarioExchanges(flags.Arg(1), *host, *name)
+	for _, file := range files {
+		fmt.Println(file)
+	}
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+func runBundlePack(args []string) int {
+	flags := flag.NewFlagSet("bundle pack", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sub-sxg bundle pack <scenario> <out.wbn>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+
+	server, err := newServer()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	b, dropped, err := server.PackBundle(flags.Arg(0))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	for _, d := range dropped {
+		log.Printf("Dropped the link of %s, which the bundle can't meet: %s", d.URL, d.Link)
+	}
+	f, err := os.Create(flags.Arg(1))
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	if _, err := b.WriteTo(f); err != nil {
+		f.Close()
+		log.Print(err)
+		return 1
+	}
+	if err := f.Close(); err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
+
+func readBundleFile(name string) (*bundle.Bundle, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return bundle.Read(f)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/horo-t/sub-sxg/subsxg"
+)
+
+// runExportWPT writes the web-platform-tests style tests of the scenarios:
+//
+//	sub-sxg export-wpt [-origin <origin>] [-alt-origin <origin>] [-path <path>] <dir>
+//
+// The exchanges are signed with the identities in cert/, which must be for
+// the hosts of the origins.
+func runExportWPT(args []string) int {
+	flags := flag.NewFlagSet("export-wpt", flag.ExitOnError)
+	opts := subsxg.WPTOptions{}
+	flags.StringVar(&opts.Origin, "origin", "https://web-platform.test:8444", "the origin of the default identity in the tests")
+	flags.StringVar(&opts.AltOrigin, "alt-origin", "https://www1.web-platform.test:8444", "the origin of the alt identity in the tests")
+	flags.StringVar(&opts.Path, "path", "/signed-exchange/sub-sxg", "the path of the directory in the tests")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sub-sxg export-wpt [flags] <dir>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+	opts.Dir = flags.Arg(0)
+
+	server, err := newServer()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	skipped, err := server.ExportWPT(opts)
+	for _, name := range skipped {
+		fmt.Fprintf(os.Stderr, "skipped %s\n", name)
+	}
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	return 0
+}
@@ -1,6 +1,24 @@
 module github.com/horo-t/sub-sxg
 
+go 1.22
+
 require (
 	github.com/WICG/webpackage v0.0.0-20190301174257-d39b53783b59
 	golang.org/x/crypto v0.0.0-20190228161510-8dd112bcdc25
+	golang.org/x/net v0.0.0-20190110200230-915654e7eabc
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/influxdata/influxdb v1.6.3 // indirect
+	github.com/konsorten/go-windows-terminal-sequences v1.0.1 // indirect
+	github.com/mrichman/hargo v0.1.2-0.20190117125451-162adce4527e // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/sirupsen/logrus v1.3.0 // indirect
+	github.com/stretchr/objx v0.1.1 // indirect
+	github.com/stretchr/testify v1.2.2 // indirect
+	github.com/ugorji/go/codec v0.0.0-20181209151446-772ced7fd4c2 // indirect
+	golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a // indirect
+	golang.org/x/text v0.3.0 // indirect
+	gopkg.in/urfave/cli.v1 v1.20.0 // indirect
 )
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/horo-t/sub-sxg/subsxg"
+)
+
+// runIntegrity prints the header-integrity of the signed exchange files and
+// URLs in args, and the allowed-alt-sxg links to them:
+//
+//	sub-sxg integrity [-json] <file or URL>...
+func runIntegrity(args []string) int {
+	flags := flag.NewFlagSet("integrity", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print the results as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: sub-sxg integrity [-json] <file or URL>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	status := 0
+	var infos []*subsxg.IntegrityInfo
+	for _, arg := range flags.Args() {
+		info, err := headerIntegrity(client, arg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", arg, err)
+			status = 1
+			continue
+		}
+		if *asJSON {
+			infos = append(infos, info)
+			continue
+		}
+		fmt.Printf("%s\n%s\nlink: %s\n", arg, info.HeaderIntegrity, info.Link)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		enc.Encode(infos)
+	}
+	return status
+}
+
+func headerIntegrity(client *http.Client, arg string) (*subsxg.IntegrityInfo, error) {
+	if strings.HasPrefix(arg, "https://") || strings.HasPrefix(arg, "http://") {
+		return subsxg.FetchHeaderIntegrity(client, arg)
+	}
+	f, err := os.Open(arg)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return subsxg.ReadHeaderIntegrity(f)
+}
@@ -1,95 +1,125 @@
 package main
 
 import (
-	"crypto"
-	"crypto/x509"
-	"encoding/pem"
+	"crypto/tls"
+	"embed"
 	"fmt"
-	"html/template"
-	"io/ioutil"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 
-	"github.com/WICG/webpackage/go/signedexchange"
+	"github.com/horo-t/sub-sxg/subsxg"
 )
 
 var (
-	demoDomainName string
-
 	certKeyFileName = "cert/cert.key"
 	certPemFileName = "cert/cert.pem"
 
 	certURLPath = "/cert/cert.cbor"
 
-	prvKey      crypto.PrivateKey
-	certs       []*x509.Certificate
-	certMessage []byte
-
-	altDemoDomainName string
-
 	altCertKeyFileName = "cert/alt_cert.key"
 	altCertPemFileName = "cert/alt_cert.pem"
 
 	altCertURLPath = "/cert/alt_cert.cbor"
-	altPrvKey      crypto.PrivateKey
-	altCerts       []*x509.Certificate
-	altCertMessage []byte
-
-	amptestnocdn_payload   []byte
-	v0js_payload           []byte
-	nikko_320_jpg_payload  []byte
-	nikko_640_jpg_payload  []byte
-	nikko_320_webp_payload []byte
-	nikko_640_webp_payload []byte
-
-	fonttest_payload      []byte
-	wapuro_mincho_payload []byte
-	corbtest_payload      []byte
 )
 
-func init() {
-	certKeyPem, _ := ioutil.ReadFile(certKeyFileName)
-	decodedCertKey, _ := pem.Decode(certKeyPem)
-	prvKey, _ = signedexchange.ParsePrivateKey(decodedCertKey.Bytes)
-
-	certPem, _ := ioutil.ReadFile(certPemFileName)
-	certs, _ = signedexchange.ParseCertificates(certPem)
-	ocsp, _ := getOCSP(certs)
-	certMessage, _ = createCertChainCBOR(certs, ocsp, nil)
+//go:embed contents
+var embeddedContents embed.FS
 
-	demoDomainName, _ = getSubjectCommonName(certPem)
+// newServer returns the server of the identities in cert/, with the contents
+// and the imported scenarios of the environment variables, and extra.
+func newServer(extra ...subsxg.Option) (*subsxg.Server, error) {
+	defaultIdentity, err := subsxg.LoadIdentity("default", certKeyFileName, certPemFileName, certURLPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the certificate: %v", err)
+	}
+	altIdentity, err := subsxg.LoadIdentity("alt", altCertKeyFileName, altCertPemFileName, altCertURLPath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load the alt certificate: %v", err)
+	}
 
-	altCertKeyPem, _ := ioutil.ReadFile(altCertKeyFileName)
-	altDecodedCertKey, _ := pem.Decode(altCertKeyPem)
-	altPrvKey, _ = signedexchange.ParsePrivateKey(altDecodedCertKey.Bytes)
+	var contents subsxg.ContentStore
+	if dir := os.Getenv("CONTENT_DIR"); dir != "" {
+		contents = subsxg.NewFSContentStore(os.DirFS(dir))
+		log.Printf("Serving contents from %s", dir)
+	} else {
+		embedded, _ := fs.Sub(embeddedContents, "contents")
+		contents = subsxg.NewFSContentStore(embedded)
+	}
 
-	altCertPem, _ := ioutil.ReadFile(altCertPemFileName)
-	altCerts, _ = signedexchange.ParseCertificates(altCertPem)
-	altOcsp, _ := getOCSP(altCerts)
-	altCertMessage, _ = createCertChainCBOR(altCerts, altOcsp, nil)
+	reportingEnabled := os.Getenv("ENABLE_REPORTING") != ""
+	if reportingEnabled {
+		log.Printf("Reporting is enabled")
+	}
 
-	altDemoDomainName, _ = getSubjectCommonName(altCertPem)
+	opts := []subsxg.Option{
+		subsxg.WithIdentity(defaultIdentity),
+		subsxg.WithAltIdentity(altIdentity),
+		subsxg.WithContentStore(contents),
+		subsxg.WithReporting(reportingEnabled),
+		subsxg.WithAdminPassword(os.Getenv("ADMIN_PASSWORD")),
+	}
+	// IMPORT_ARCHIVES is the comma separated HAR or WARC files which are
+	// imported as scenarios. IMPORT_ARCHIVE_IDENTITY=alt signs them for the
+	// alt domain.
+	if archives := os.Getenv("IMPORT_ARCHIVES"); archives != "" {
+		for _, name := range strings.Split(archives, ",") {
+			archive, err := readArchiveFile(name)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read the archive %s: %v", name, err)
+			}
+			log.Printf("Importing %s", name)
+			opts = append(opts, subsxg.WithArchive(archive, subsxg.ArchiveOptions{
+				Alt: os.Getenv("IMPORT_ARCHIVE_IDENTITY") == "alt",
+			}))
+		}
+	}
+	// IMPORT_BUNDLES is the comma separated Web Bundles which are imported as
+	// scenarios.
+	if bundles := os.Getenv("IMPORT_BUNDLES"); bundles != "" {
+		for _, name := range strings.Split(bundles, ",") {
+			b, err := readBundleFile(name)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read the bundle %s: %v", name, err)
+			}
+			log.Printf("Importing %s", name)
+			opts = append(opts, subsxg.WithBundle(b, subsxg.BundleOptions{}))
+		}
+	}
+	return subsxg.New(append(opts, extra...)...)
+}
 
-	amptestnocdn_payload, _ = ioutil.ReadFile("contents/amptestnocdn.html")
-	v0js_payload, _ = ioutil.ReadFile("contents/v0.js")
-	nikko_320_jpg_payload, _ = ioutil.ReadFile("contents/nikko_320.jpg")
-	nikko_640_jpg_payload, _ = ioutil.ReadFile("contents/nikko_640.jpg")
-	nikko_320_webp_payload, _ = ioutil.ReadFile("contents/nikko_320.webp")
-	nikko_640_webp_payload, _ = ioutil.ReadFile("contents/nikko_640.webp")
+func readArchiveFile(name string) (*subsxg.Archive, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return subsxg.ReadArchive(f)
+}
 
-	fonttest_payload, _ = ioutil.ReadFile("contents/fonttest.html")
-	wapuro_mincho_payload, _ = ioutil.ReadFile("contents/wapuro-mincho.woff2")
-	corbtest_payload, _ = ioutil.ReadFile("contents/corbtest.html")
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "integrity":
+			os.Exit(runIntegrity(os.Args[2:]))
+		case "export-wpt":
+			os.Exit(runExportWPT(os.Args[2:]))
+		case "bundle":
+			os.Exit(runBundle(os.Args[2:]))
+		}
+	}
 
-	log.Printf("demoDomainName: %s", demoDomainName)
+	server, err := newServer()
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("demoDomainName: %s", server.DemoDomainName())
 	log.Printf("initialized")
-}
 
-func main() {
-	http.HandleFunc("/cert/", certHandler)
-	http.HandleFunc("/sxg/", signedExchangeHandler)
-	http.HandleFunc("/", indexHandler)
+	go server.RefreshOCSPPeriodically()
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -97,44 +127,22 @@ func main() {
 		log.Printf("Defaulting to port %s", port)
 	}
 
-	log.Printf("Listening on port %s", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), nil))
-}
-
-func indexHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
-	}
-
-	t := template.Must(template.ParseFiles("templates/index.html"))
-
-	type Data struct {
-		Host string
-		SXGs []string
-	}
-	data := Data{
-		Host: r.Host,
-		SXGs: []string{
-			"hello.sxg",
-			"hello_certpush.sxg",
-			"hello_data_url_cert.sxg",
-			"amptestnocdn.sxg",
-			"amptestnocdn_js_preload.sxg",
-			"amptestnocdn_js_img_preload.sxg",
-			"amptestnocdn_js_img_vary_preload.sxg",
-			"amptestnocdn_js_preload_error.sxg",
-			"amptestnocdn_js_img_preload_error.sxg",
-			"loop.sxg",
-			"fonttest.sxg",
-			"cors_fonttest.sxg",
-			"corbtest.sxg",
-			"nosniff_corbtest.sxg",
-			"nosniffable_corbtest.sxg",
-		},
+	if tlsPort := os.Getenv("TLS_PORT"); tlsPort != "" {
+		// The local TLS mode serves both domains of the identities, including
+		// the unsigned contents at the content URLs.
+		tlsServer := &http.Server{
+			Addr:    fmt.Sprintf(":%s", tlsPort),
+			Handler: server.OriginHandler(server),
+			TLSConfig: &tls.Config{
+				GetCertificate: newTLSCertificates(server.DemoDomainName()).getCertificate,
+			},
+		}
+		log.Printf("Listening on TLS port %s", tlsPort)
+		go func() {
+			log.Fatal(tlsServer.ListenAndServeTLS("", ""))
+		}()
 	}
 
-	if err := t.ExecuteTemplate(w, "index.html", data); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-	}
+	log.Printf("Listening on port %s", port)
+	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", port), server))
 }
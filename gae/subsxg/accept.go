@@ -0,0 +1,48 @@
+package subsxg
+
+import (
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+const signedExchangeMIMEType = "application/signed-exchange"
+
+// sxgVersionParam returns the value of the "v" parameter which identifies ver
+// in the application/signed-exchange MIME type. (e.g. "b3" for 1b3)
+func sxgVersionParam(ver version.Version) string {
+	return strings.TrimPrefix(string(ver), "1")
+}
+
+func sxgContentType(ver version.Version) string {
+	return signedExchangeMIMEType + ";v=" + sxgVersionParam(ver)
+}
+
+// acceptsSignedExchange reports whether the Accept header in h lists a signed
+// exchange of version ver with a nonzero q-value. The q-value isn't compared
+// with the other media ranges, since the browsers rank text/html higher.
+// Like real publishers, wildcard media ranges are not treated as accepting
+// signed exchanges, and the "v" parameter must match ver.
+func acceptsSignedExchange(h http.Header, ver version.Version) bool {
+	for _, accept := range h["Accept"] {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+			if err != nil || mediaType != signedExchangeMIMEType {
+				continue
+			}
+			if params["v"] != sxgVersionParam(ver) {
+				continue
+			}
+			if q, ok := params["q"]; ok {
+				if qvalue, err := strconv.ParseFloat(q, 64); err != nil || qvalue <= 0 {
+					continue
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
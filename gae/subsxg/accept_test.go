@@ -0,0 +1,33 @@
+package subsxg
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+func TestAcceptsSignedExchange(t *testing.T) {
+	for accept, want := range map[string]bool{
+		testAccept: true,
+		"text/html,application/xhtml+xml,application/signed-exchange;v=b3;q=0.9,*/*;q=0.8": true,
+		"application/signed-exchange;v=b3":                                                 true,
+		"application/signed-exchange;v=b3;q=0":                                             false,
+		"application/signed-exchange;v=b3;q=0.0":                                           false,
+		"application/signed-exchange;v=b3;q=x":                                             false,
+		"application/signed-exchange;v=b2":                                                 false,
+		"application/signed-exchange":                                                      false,
+		"*/*":                                                                              false,
+		"application/*":                                                                    false,
+		"text/html":                                                                        false,
+		"":                                                                                 false,
+	} {
+		h := http.Header{}
+		if accept != "" {
+			h.Set("Accept", accept)
+		}
+		if got := acceptsSignedExchange(h, version.Version1b3); got != want {
+			t.Errorf("acceptsSignedExchange(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package subsxg
+
+import (
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	adminURLPath       = "/admin"
+	adminStatusURLPath = "/admin/status.json"
+
+	certExpiryWarning = 14 * 24 * time.Hour
+	ocspExpiryWarning = 2 * 24 * time.Hour
+)
+
+// The CanSignHttpExchanges extension.
+var canSignHttpExchangesOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 1, 22}
+
+type identityStatus struct {
+	Name                 string    `json:"name"`
+	Subject              string    `json:"subject"`
+	SANs                 []string  `json:"sans"`
+	NotAfter             time.Time `json:"not_after"`
+	CanSignHttpExchanges bool      `json:"can_sign_http_exchanges"`
+	OCSPStatus           string    `json:"ocsp_status"`
+	OCSPProducedAt       time.Time `json:"ocsp_produced_at"`
+	OCSPNextUpdate       time.Time `json:"ocsp_next_update"`
+	OCSPError            string    `json:"ocsp_error,omitempty"`
+	CertChainSHA256      string    `json:"cert_chain_sha256"`
+	LastSigningError     string    `json:"last_signing_error,omitempty"`
+	LastSigningErrorTime time.Time `json:"last_signing_error_time"`
+	Warnings             []string  `json:"warnings"`
+}
+
+func ocspStatusString(status int) string {
+	switch status {
+	case ocsp.Good:
+		return "good"
+	case ocsp.Revoked:
+		return "revoked"
+	default:
+		return "unknown"
+	}
+}
+
+func (id *Identity) status(now time.Time) identityStatus {
+	cert := id.certs[0]
+	s := identityStatus{
+		Name:            id.name,
+		Subject:         cert.Subject.String(),
+		SANs:            cert.DNSNames,
+		NotAfter:        cert.NotAfter,
+		CertChainSHA256: id.certMessageHash(),
+		Warnings:        []string{},
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(canSignHttpExchangesOID) {
+			s.CanSignHttpExchanges = true
+		}
+	}
+	if !s.CanSignHttpExchanges {
+		s.Warnings = append(s.Warnings, "The certificate doesn't have the CanSignHttpExchanges extension.")
+	}
+	if cert.NotAfter.Sub(now) < certExpiryWarning {
+		s.Warnings = append(s.Warnings, "The certificate expires at "+cert.NotAfter.Format(time.RFC3339)+".")
+	}
+
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	s.OCSPStatus = "missing"
+	if id.ocspResponse != nil {
+		s.OCSPStatus = ocspStatusString(id.ocspResponse.Status)
+		s.OCSPProducedAt = id.ocspResponse.ProducedAt
+		s.OCSPNextUpdate = id.ocspResponse.NextUpdate
+		if id.ocspResponse.Status != ocsp.Good {
+			s.Warnings = append(s.Warnings, "The OCSP status is "+s.OCSPStatus+".")
+		}
+		if s.OCSPNextUpdate.Sub(now) < ocspExpiryWarning {
+			s.Warnings = append(s.Warnings, "The OCSP response expires at "+s.OCSPNextUpdate.Format(time.RFC3339)+".")
+		}
+	} else {
+		s.Warnings = append(s.Warnings, "No OCSP response.")
+	}
+	if id.ocspErr != nil {
+		s.OCSPError = id.ocspErr.Error()
+	}
+	if id.lastErr != nil {
+		s.LastSigningError = id.lastErr.Error()
+		s.LastSigningErrorTime = id.lastErrTime
+	}
+	return s
+}
+
+// authorizeAdmin checks the admin password with basic authentication. The
+// admin pages are disabled when it is not set.
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	password := s.adminPassword
+	if password == "" {
+		http.NotFound(w, r)
+		return false
+	}
+	_, given, ok := r.BasicAuth()
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(password)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="sub-sxg admin"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	now := s.now()
+	var statuses []identityStatus
+	for _, id := range []*Identity{s.defaultIdentity, s.altIdentity} {
+		statuses = append(statuses, id.status(now))
+	}
+
+	switch r.URL.Path {
+	case adminStatusURLPath:
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(statuses)
+	case adminURLPath:
+		if err := templates.ExecuteTemplate(w, "admin.html", statuses); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
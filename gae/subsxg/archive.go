@@ -0,0 +1,189 @@
+package subsxg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// An Archive is the responses captured in a HAR or a WARC file.
+type Archive struct {
+	responses []*archivedResponse
+}
+
+type archivedResponse struct {
+	url    *url.URL
+	status int
+	header http.Header
+	body   []byte
+}
+
+// ReadArchive reads a HAR file, or a WARC file which may be gzipped. Only the
+// responses to GET requests are kept.
+func ReadArchive(r io.Reader) (*Archive, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		br = bufio.NewReader(zr)
+	}
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, errors.New("empty archive")
+		}
+		if b[0] != ' ' && b[0] != '\t' && b[0] != '\r' && b[0] != '\n' {
+			break
+		}
+		br.ReadByte()
+	}
+	head, _ := br.Peek(5)
+	switch {
+	case head[0] == '{':
+		return readHARArchive(br)
+	case string(head) == "WARC/":
+		return readWARCArchive(br)
+	}
+	return nil, errors.New("neither a HAR nor a WARC file")
+}
+
+func readHARArchive(r io.Reader) (*Archive, error) {
+	var f harFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, err
+	}
+	a := &Archive{}
+	for _, entry := range f.Log.Entries {
+		if entry.Request.Method != http.MethodGet {
+			continue
+		}
+		u, err := url.Parse(entry.Request.URL)
+		if err != nil {
+			continue
+		}
+		header := http.Header{}
+		for _, h := range entry.Response.Headers {
+			header.Add(h.Name, h.Value)
+		}
+		body := []byte(entry.Response.Content.Text)
+		if entry.Response.Content.Encoding == "base64" {
+			if body, err = base64.StdEncoding.DecodeString(entry.Response.Content.Text); err != nil {
+				return nil, fmt.Errorf("%s: %v", entry.Request.URL, err)
+			}
+		}
+		// The HAR content is decoded.
+		header.Del("Content-Encoding")
+		a.responses = append(a.responses, &archivedResponse{url: u, status: entry.Response.Status, header: header, body: body})
+	}
+	return a, nil
+}
+
+func readWARCArchive(br *bufio.Reader) (*Archive, error) {
+	a := &Archive{}
+	tr := textproto.NewReader(br)
+	for {
+		line, err := tr.ReadLine()
+		if err == io.EOF {
+			return a, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if line == "" {
+			continue
+		}
+		if !strings.HasPrefix(line, "WARC/") {
+			return nil, fmt.Errorf("unexpected line %q", line)
+		}
+		header, err := tr.ReadMIMEHeader()
+		if err != nil {
+			return nil, err
+		}
+		length, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Content-Length of a WARC record: %v", err)
+		}
+		block := make([]byte, length)
+		if _, err := io.ReadFull(br, block); err != nil {
+			return nil, err
+		}
+		if header.Get("WARC-Type") != "response" || !strings.HasPrefix(header.Get("Content-Type"), "application/http") {
+			continue
+		}
+		res, err := readWARCResponse(header.Get("WARC-Target-URI"), block)
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			a.responses = append(a.responses, res)
+		}
+	}
+}
+
+// readWARCResponse parses the HTTP response in the block of a WARC response
+// record. The responses with unsupported content codings are dropped.
+func readWARCResponse(target string, block []byte) (*archivedResponse, error) {
+	u, err := url.Parse(strings.Trim(target, "<>"))
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(block)), &http.Request{Method: http.MethodGet})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", target, err)
+	}
+	defer res.Body.Close()
+	var body io.Reader = res.Body
+	switch res.Header.Get("Content-Encoding") {
+	case "":
+	case "gzip":
+		zr, err := gzip.NewReader(res.Body)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", target, err)
+		}
+		body = zr
+		res.Header.Del("Content-Encoding")
+	default:
+		return nil, nil
+	}
+	payload, err := ioutil.ReadAll(body)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("%s: %v", target, err)
+	}
+	res.Header.Del("Content-Length")
+	res.Header.Del("Transfer-Encoding")
+	return &archivedResponse{url: u, status: res.StatusCode, header: res.Header, body: payload}, nil
+}
+
+// mainDocument returns the response of rawURL, or the first HTML response if
+// rawURL is empty.
+func (a *Archive) mainDocument(rawURL string) (*archivedResponse, error) {
+	for _, res := range a.responses {
+		if res.status != http.StatusOK {
+			continue
+		}
+		if rawURL == "" && strings.HasPrefix(res.header.Get("Content-Type"), "text/html") {
+			return res, nil
+		}
+		if rawURL != "" && res.url.String() == rawURL {
+			return res, nil
+		}
+	}
+	if rawURL == "" {
+		return nil, errors.New("no HTML document in the archive")
+	}
+	return nil, fmt.Errorf("no successful response of %s in the archive", rawURL)
+}
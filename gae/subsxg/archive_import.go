@@ -0,0 +1,316 @@
+package subsxg
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/publicsuffix"
+)
+
+// ArchiveOptions are the options of the scenario imported from an Archive.
+type ArchiveOptions struct {
+	// Name is the name of the scenario. It defaults to the host of the main
+	// document, such as archive_www_example_com.sxg.
+	Name string
+	// MainURL is the URL of the main document. It defaults to the first HTML
+	// response in the archive.
+	MainURL string
+	// Alt signs the contents for the domain of the alt identity instead of
+	// the demo domain.
+	Alt bool
+}
+
+type archiveImport struct {
+	archive *Archive
+	opts    ArchiveOptions
+}
+
+// WithArchive imports the main document of a and its same-site
+// subresources into the content store, and adds a scenario which preloads
+// the subresources as signed exchanges.
+func WithArchive(a *Archive, opts ArchiveOptions) Option {
+	return func(s *Server) { s.archives = append(s.archives, archiveImport{archive: a, opts: opts}) }
+}
+
+// The imported contents are stored under importedContentsPrefix + the name
+// of the scenario + the original host and path.
+const importedContentsPrefix = "imported/"
+
+func archiveScenarioName(u *url.URL) string {
+	return "archive_" + strings.NewReplacer(".", "_", "-", "_", ":", "_").Replace(u.Host) + ".sxg"
+}
+
+// archiveKey identifies the responses regardless of the scheme.
+func archiveKey(u *url.URL) string {
+	return u.Host + u.EscapedPath() + "?" + u.RawQuery
+}
+
+// archiveContentPath returns the path in the content store of the response
+// of u. The queries are kept apart by their hashes.
+func archiveContentPath(prefix string, u *url.URL) string {
+	p := u.Path
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += "index.html"
+	}
+	p = path.Clean("/" + p)
+	if u.RawQuery != "" {
+		sum := sha256.Sum256([]byte(u.RawQuery))
+		ext := path.Ext(p)
+		p = strings.TrimSuffix(p, ext) + "_" + hex.EncodeToString(sum[:4]) + ext
+	}
+	return prefix + u.Hostname() + p
+}
+
+// archiveRewriter maps the same-site URLs in the archive to the content URLs
+// of the imported contents.
+type archiveRewriter struct {
+	domain string
+	paths  map[string]string
+}
+
+func (w *archiveRewriter) rewriteURL(ref string, base *url.URL) (string, bool) {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") {
+		return ref, false
+	}
+	p, ok := w.paths[archiveKey(u)]
+	if !ok {
+		return ref, false
+	}
+	rewritten := "https://" + w.domain + "/" + p
+	if u.Fragment != "" {
+		rewritten += "#" + u.Fragment
+	}
+	return rewritten, true
+}
+
+func (w *archiveRewriter) rewriteSrcset(srcset string, base *url.URL) (string, bool) {
+	candidates := strings.Split(srcset, ",")
+	changed := false
+	for i, candidate := range candidates {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		if u, ok := w.rewriteURL(fields[0], base); ok {
+			fields[0] = u
+			candidates[i] = strings.Join(fields, " ")
+			changed = true
+		}
+	}
+	return strings.Join(candidates, ", "), changed
+}
+
+func (w *archiveRewriter) rewriteCSS(css string, base *url.URL) string {
+	var out strings.Builder
+	last := 0
+	for _, m := range cssURL.FindAllStringSubmatchIndex(css, -1) {
+		if u, ok := w.rewriteURL(css[m[2]:m[3]], base); ok {
+			out.WriteString(css[last:m[2]])
+			out.WriteString(u)
+			last = m[3]
+		}
+	}
+	out.WriteString(css[last:])
+	return out.String()
+}
+
+// rewriteHTML rewrites the URLs in the attributes and in the inline CSS. The
+// other tokens are kept as they are.
+func (w *archiveRewriter) rewriteHTML(payload []byte, base *url.URL) []byte {
+	var out bytes.Buffer
+	z := html.NewTokenizer(bytes.NewReader(payload))
+	inStyle := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		raw := append([]byte(nil), z.Raw()...)
+		token := z.Token()
+		switch tt {
+		case html.TextToken:
+			if inStyle {
+				out.WriteString(w.rewriteCSS(string(raw), base))
+				continue
+			}
+		case html.EndTagToken:
+			if token.Data == "style" {
+				inStyle = false
+			}
+		case html.StartTagToken, html.SelfClosingTagToken:
+			if token.Data == "style" {
+				inStyle = tt == html.StartTagToken
+			}
+			changed := false
+			for i, a := range token.Attr {
+				var v string
+				var ok bool
+				switch a.Key {
+				case "src", "href", "poster":
+					v, ok = w.rewriteURL(a.Val, base)
+				case "srcset", "imagesrcset":
+					v, ok = w.rewriteSrcset(a.Val, base)
+				case "style":
+					v = w.rewriteCSS(a.Val, base)
+					ok = v != a.Val
+				}
+				if ok {
+					token.Attr[i].Val = v
+					changed = true
+				}
+			}
+			if changed {
+				out.WriteString(token.String())
+				continue
+			}
+		}
+		out.Write(raw)
+	}
+	return out.Bytes()
+}
+
+func (w *archiveRewriter) rewrite(res *archivedResponse) []byte {
+	contentType := strings.ToLower(res.header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(contentType, "text/html"):
+		return w.rewriteHTML(res.body, res.url)
+	case strings.HasPrefix(contentType, "text/css"):
+		return []byte(w.rewriteCSS(string(res.body), res.url))
+	}
+	return res.body
+}
+
+// layeredContentStore looks the paths up in the stores in order.
+type layeredContentStore struct {
+	stores []ContentStore
+}
+
+func (s *layeredContentStore) Get(p string) (*Content, bool) {
+	for _, store := range s.stores {
+		if c, ok := store.Get(p); ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func (s *layeredContentStore) Paths() []string {
+	seen := map[string]bool{}
+	var paths []string
+	for _, store := range s.stores {
+		for _, p := range store.Paths() {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// importArchive adds the contents and the scenario of an archive. The
+// successful responses on the site of the main document are imported, and
+// the URLs of each other in the HTML and CSS payloads are rewritten to the
+// content URLs on the signing domain.
+func (s *Server) importArchive(im archiveImport) error {
+	main, err := im.archive.mainDocument(im.opts.MainURL)
+	if err != nil {
+		return err
+	}
+	site, err := publicsuffix.EffectiveTLDPlusOne(main.url.Hostname())
+	if err != nil {
+		return err
+	}
+	name := im.opts.Name
+	if name == "" {
+		name = archiveScenarioName(main.url)
+	}
+	if !strings.HasSuffix(name, ".sxg") {
+		name += ".sxg"
+	}
+	for _, sc := range s.scenarios {
+		if sc.Name == name {
+			return fmt.Errorf("the scenario %s already exists", name)
+		}
+	}
+	domain := s.demoDomainName
+	if im.opts.Alt {
+		domain = s.altDemoDomainName
+	}
+
+	w := &archiveRewriter{domain: domain, paths: map[string]string{}}
+	prefix := importedContentsPrefix + strings.TrimSuffix(name, ".sxg") + "/"
+	var responses []*archivedResponse
+	for _, res := range im.archive.responses {
+		if res.status != http.StatusOK {
+			continue
+		}
+		if resSite, err := publicsuffix.EffectiveTLDPlusOne(res.url.Hostname()); err != nil || resSite != site {
+			continue
+		}
+		key := archiveKey(res.url)
+		if _, ok := w.paths[key]; ok {
+			continue
+		}
+		w.paths[key] = archiveContentPath(prefix, res.url)
+		responses = append(responses, res)
+	}
+
+	store := &memContentStore{contents: map[string]*Content{}}
+	for _, res := range responses {
+		p := w.paths[archiveKey(res.url)]
+		c := NewContent(p, w.rewrite(res))
+		if contentType := res.header.Get("Content-Type"); contentType != "" {
+			c.contentType = contentType
+		}
+		store.contents[p] = c
+	}
+	s.contents = &layeredContentStore{stores: []ContentStore{store, s.contents}}
+
+	doc := store.contents[w.paths[archiveKey(main.url)]]
+	contentURL := "https://" + domain + "/" + doc.path
+	base, _ := url.Parse(contentURL)
+	subresources := map[string]Outcome{}
+	for _, sub := range discoverSubresources(doc.payload, base) {
+		urls := sub.candidates
+		if len(urls) == 0 {
+			urls = []*url.URL{sub.url}
+		}
+		for _, u := range urls {
+			if _, c, ok := s.autoSXGURL(u, ""); ok {
+				subresources[strings.TrimPrefix(autoSXGPathPrefix, "/sxg/")+c.path+".sxg"] = OutcomeSXG
+			}
+		}
+	}
+
+	alt := im.opts.Alt
+	s.scenarios = append(s.scenarios, Scenario{
+		Name:         name,
+		Listed:       true,
+		Subresources: subresources,
+		setup: func(s *Server, params *exchangeParams, r *http.Request) {
+			if alt {
+				params.identity = s.altIdentity
+				params.certUrl = "https://" + r.Host + s.altIdentity.certURLPath
+				params.validityUrl = "https://" + s.altDemoDomainName + "/cert/null.validity.msg"
+			}
+			params.contentUrl = contentURL
+			params.contentType = doc.contentType
+			params.payload = doc.payload
+			params.resHeader.Add("cache-control", "public, max-age=600")
+			params.outerHeader.Add("cache-control", "public, max-age=600")
+			s.addDiscoveredSubresourceLinks(params, r)
+		},
+	})
+	return nil
+}
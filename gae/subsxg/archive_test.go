@@ -0,0 +1,140 @@
+package subsxg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const (
+	archivedPage = `<!DOCTYPE html>
+<html><head>
+<link rel="stylesheet" href="https://static.example.com/style.css">
+<script src="https://cdn.other.test/lib.js"></script>
+<script src="/js/app.js"></script>
+<style>@font-face { font-family: f; src: url(fonts/f.woff2); }</style>
+<script>if (1 < 2 && "/js/app.js") {}</script>
+</head><body>
+<img src="img/a.jpg" srcset="img/a.jpg 1x, img/a_2x.jpg?q=1 2x">
+</body></html>`
+	archivedCSS = `body { background: url("//www.example.com/page/img/a.jpg"); }`
+)
+
+// archivedResponses are the URLs, the content types and the bodies of the
+// responses in the test archives.
+var archivedResponses = [][3]string{
+	{"https://www.example.com/page/", "text/html; charset=utf-8", archivedPage},
+	{"https://static.example.com/style.css", "text/css", archivedCSS},
+	{"https://cdn.other.test/lib.js", "text/javascript", "lib();"},
+	{"https://www.example.com/js/app.js", "application/javascript", "app();"},
+	{"https://www.example.com/page/fonts/f.woff2", "font/woff2", "wOF2"},
+	{"https://www.example.com/page/img/a.jpg", "image/jpeg", "\xff\xd8\xff"},
+	{"https://www.example.com/page/img/a_2x.jpg?q=1", "image/jpeg", "\xff\xd8\xff\xe0"},
+}
+
+func testHAR(t *testing.T) []byte {
+	f := harFile{Log: harLog{Version: "1.2"}}
+	for _, r := range archivedResponses {
+		entry := harEntry{
+			Request:  harRequest{Method: "GET", URL: r[0]},
+			Response: harResponse{Status: 200, Headers: []harNameValue{{Name: "Content-Type", Value: r[1]}}},
+		}
+		entry.Response.Content = harContent{Text: base64.StdEncoding.EncodeToString([]byte(r[2])), Encoding: "base64"}
+		f.Log.Entries = append(f.Log.Entries, entry)
+	}
+	j, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+func testWARC(t *testing.T) []byte {
+	var warc bytes.Buffer
+	zw := gzip.NewWriter(&warc)
+	fmt.Fprintf(zw, "WARC/1.0\r\nWARC-Type: warcinfo\r\nContent-Length: 0\r\n\r\n\r\n\r\n")
+	for _, r := range archivedResponses {
+		var body bytes.Buffer
+		gz := gzip.NewWriter(&body)
+		gz.Write([]byte(r[2]))
+		gz.Close()
+		block := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Type: %s\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n%s", r[1], body.Len(), body.String())
+		fmt.Fprintf(zw, "WARC/1.0\r\nWARC-Type: request\r\nContent-Length: 0\r\n\r\n\r\n\r\n")
+		fmt.Fprintf(zw, "WARC/1.0\r\nWARC-Type: response\r\nWARC-Target-URI: %s\r\nContent-Type: application/http; msgtype=response\r\nContent-Length: %d\r\n\r\n%s\r\n\r\n", r[0], len(block), block)
+	}
+	zw.Close()
+	return warc.Bytes()
+}
+
+func TestImportArchive(t *testing.T) {
+	for name, data := range map[string][]byte{"har": testHAR(t), "warc": testWARC(t)} {
+		t.Run(name, func(t *testing.T) {
+			a, err := ReadArchive(bytes.NewReader(data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(a.responses) != len(archivedResponses) {
+				t.Fatalf("%d responses, want %d", len(a.responses), len(archivedResponses))
+			}
+			s := newTestServer(t, WithScenarios(), WithArchive(a, ArchiveOptions{}))
+			if len(s.scenarios) != 1 || s.scenarios[0].Name != "archive_www_example_com.sxg" {
+				t.Fatalf("scenarios %v", s.scenarios)
+			}
+			prefix := "https://" + testDomainName + "/imported/archive_www_example_com/"
+
+			page, ok := s.contents.Get("imported/archive_www_example_com/www.example.com/page/index.html")
+			if !ok {
+				t.Fatal("the main document is not imported")
+			}
+			for _, want := range []string{
+				`href="` + prefix + `static.example.com/style.css"`,
+				`src="https://cdn.other.test/lib.js"`,
+				`src="` + prefix + `www.example.com/js/app.js"`,
+				`url(` + prefix + `www.example.com/page/fonts/f.woff2)`,
+				`<script>if (1 < 2 && "/js/app.js") {}</script>`,
+				prefix + `www.example.com/page/img/a_2x_`,
+			} {
+				if !strings.Contains(string(page.payload), want) {
+					t.Errorf("the main document doesn't contain %s:\n%s", want, page.payload)
+				}
+			}
+			if css, ok := s.contents.Get("imported/archive_www_example_com/static.example.com/style.css"); !ok || !strings.Contains(string(css.payload), prefix+"www.example.com/page/img/a.jpg") {
+				t.Errorf("the stylesheet is not rewritten")
+			}
+			if js, ok := s.contents.Get("imported/archive_www_example_com/www.example.com/js/app.js"); !ok || js.contentType != "application/javascript" {
+				t.Errorf("the script is not imported with its content type")
+			}
+			for _, p := range s.contents.Paths() {
+				if strings.Contains(p, "other.test") {
+					t.Errorf("the cross-site %s is imported", p)
+				}
+			}
+
+			sc := s.scenarios[0]
+			if len(sc.Subresources) != 5 {
+				t.Errorf("subresources %v", sc.Subresources)
+			}
+			rec := get(t, s, "https://"+testHost+"/sxg/"+sc.Name, testAccept)
+			e := readExchange(t, rec)
+			if e.RequestURI != prefix+"www.example.com/page/index.html" {
+				t.Errorf("content URL %s", e.RequestURI)
+			}
+			if problems := lintLinks(rec.Header()["Link"], e.ResponseHeaders["Link"]); len(problems) > 0 {
+				t.Errorf("lint: %s", strings.Join(problems, "; "))
+			}
+			checkAlternates(t, s, sc.Name, rec.Header()["Link"], e.ResponseHeaders["Link"])
+		})
+	}
+}
+
+func TestReadArchiveErrors(t *testing.T) {
+	for _, data := range []string{"", "  ", "<html>", "WARC/1.0\r\nContent-Length: x\r\n\r\n"} {
+		if _, err := ReadArchive(strings.NewReader(data)); err == nil {
+			t.Errorf("ReadArchive(%q) succeeded", data)
+		}
+	}
+}
@@ -0,0 +1,341 @@
+package subsxg
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+	"github.com/WICG/webpackage/go/signedexchange/structuredheader"
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+// The cache emulator plays the distributor, like the AMP cache does. It
+// fetches the signed exchanges of https://<host>/<path> from the publisher
+// handlers and serves them at cachePathPrefix + <host>/<path>.
+const cachePathPrefix = "/c/s/"
+
+// The restrictions which the cache enforces on the exchanges.
+const (
+	maxCachedExchangeSize  = 8 << 20
+	maxSignatureLifetime   = 7 * 24 * time.Hour
+	cachedCertMaxAge       = 100
+	cachedExchangeMaxAge   = 600
+	cacheEmulatorUserAgent = "sub-sxg cache emulator"
+)
+
+// The only outer response headers which are forwarded from the publisher.
+var cacheForwardedHeaders = []string{"Content-Type", "Link"}
+
+var certURLParam = regexp.MustCompile(`cert-url="[^"]*"`)
+
+// The bounds of the exchanges which the cache emulator holds. The least
+// recently used exchanges are evicted beyond them.
+const (
+	maxCachedExchanges = 256
+	maxCacheSize       = 64 << 20
+)
+
+type cachedExchange struct {
+	key     string
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// exchangeCache is an LRU cache of the exchanges, bounded by the number of
+// the entries and by the total size of their bodies.
+type exchangeCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru has the *cachedExchange values, the most recently used first.
+	lru  *list.List
+	size int
+}
+
+func newExchangeCache() *exchangeCache {
+	return &exchangeCache{entries: map[string]*list.Element{}, lru: list.New()}
+}
+
+func (c *exchangeCache) get(key string, now time.Time) (*cachedExchange, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := elem.Value.(*cachedExchange)
+	if now.After(e.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	return e, true
+}
+
+func (c *exchangeCache) put(key string, e *cachedExchange, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	e.key = key
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += len(e.body)
+
+	// Drop the expired exchanges first, then the least recently used ones.
+	for elem := c.lru.Back(); elem != nil; {
+		prev := elem.Prev()
+		if now.After(elem.Value.(*cachedExchange).expires) {
+			c.remove(elem)
+		}
+		elem = prev
+	}
+	for c.lru.Len() > maxCachedExchanges || c.size > maxCacheSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *exchangeCache) remove(elem *list.Element) {
+	e := c.lru.Remove(elem).(*cachedExchange)
+	delete(c.entries, e.key)
+	c.size -= len(e.body)
+}
+
+// cacheURL returns the URL at which the cache emulator running on cacheHost
+// serves publisherURL.
+func cacheURL(cacheHost string, publisherURL *url.URL) string {
+	u := "https://" + cacheHost + cachePathPrefix + publisherURL.Host + publisherURL.EscapedPath()
+	if publisherURL.RawQuery != "" {
+		u += "?" + publisherURL.RawQuery
+	}
+	return u
+}
+
+// internalFetchKey marks the context of the requests of fetchFromPublisher,
+// which never leave the process.
+type internalFetchKey struct{}
+
+func isInternalFetch(r *http.Request) bool {
+	internal, _ := r.Context().Value(internalFetchKey{}).(bool)
+	return internal
+}
+
+// fetchFromPublisher runs the publisher handlers for u in process.
+func (s *Server) fetchFromPublisher(u *url.URL, accept string) (*httptest.ResponseRecorder, error) {
+	if strings.HasPrefix(u.Path, cachePathPrefix) {
+		return nil, errors.New("the cache can't fetch from itself")
+	}
+	ctx := context.WithValue(context.Background(), internalFetchKey{}, true)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", cacheEmulatorUserAgent)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec, nil
+}
+
+func (s *Server) fetchCertForVerification(certURL string) ([]byte, error) {
+	if strings.HasPrefix(certURL, "data:") {
+		i := strings.Index(certURL, ";base64,")
+		if i < 0 {
+			return nil, errors.New("unsupported data URL")
+		}
+		return base64.StdEncoding.DecodeString(certURL[i+len(";base64,"):])
+	}
+	u, err := url.Parse(certURL)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := s.fetchFromPublisher(u, "application/cert-chain+cbor")
+	if err != nil {
+		return nil, err
+	}
+	if rec.Code != http.StatusOK {
+		return nil, fmt.Errorf("cert-url %q responded with %d", certURL, rec.Code)
+	}
+	return rec.Body.Bytes(), nil
+}
+
+func signatureParams(e *signedexchange.Exchange) (structuredheader.Parameters, error) {
+	signatures, err := structuredheader.ParseParameterisedList(e.SignatureHeaderValue)
+	if err != nil {
+		return nil, err
+	}
+	if len(signatures) != 1 {
+		return nil, fmt.Errorf("has %d signatures", len(signatures))
+	}
+	return signatures[0].Params, nil
+}
+
+// validateForCache checks the exchange fetched from the publisher like a
+// signed exchange cache does, and returns the time when the signature
+// expires.
+func (s *Server) validateForCache(rec *httptest.ResponseRecorder, now time.Time) (*signedexchange.Exchange, time.Time, error) {
+	if rec.Code != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("publisher responded with %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != sxgContentType(version.Version1b3) {
+		return nil, time.Time{}, fmt.Errorf("unexpected content type %q", ct)
+	}
+	if rec.Body.Len() > maxCachedExchangeSize {
+		return nil, time.Time{}, fmt.Errorf("exchange is %d bytes, larger than %d bytes", rec.Body.Len(), maxCachedExchangeSize)
+	}
+	e, err := signedexchange.ReadExchange(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	if e.ResponseStatus != http.StatusOK {
+		return nil, time.Time{}, fmt.Errorf("inner response status is %d", e.ResponseStatus)
+	}
+	if err := signedexchange.VerifyUncachedHeader(e.ResponseHeaders); err != nil {
+		return nil, time.Time{}, err
+	}
+	cacheControl := strings.ToLower(e.ResponseHeaders.Get("Cache-Control"))
+	if strings.Contains(cacheControl, "no-cache") {
+		return nil, time.Time{}, errors.New("inner response has the no-cache directive")
+	}
+	var logBuf bytes.Buffer
+	if !e.IsCacheable(log.New(&logBuf, "", 0)) {
+		return nil, time.Time{}, fmt.Errorf("inner response is not cacheable: %s", strings.TrimSpace(logBuf.String()))
+	}
+
+	params, err := signatureParams(e)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	date, _ := params["date"].(int64)
+	expires, _ := params["expires"].(int64)
+	if time.Duration(expires-date)*time.Second > maxSignatureLifetime {
+		return nil, time.Time{}, fmt.Errorf("signature lifetime %v is longer than %v", time.Duration(expires-date)*time.Second, maxSignatureLifetime)
+	}
+	if _, ok := e.Verify(now, s.fetchCertForVerification, log.New(&logBuf, "", 0)); !ok {
+		return nil, time.Time{}, fmt.Errorf("signature verification failed: %s", strings.TrimSpace(logBuf.String()))
+	}
+	return e, time.Unix(expires, 0), nil
+}
+
+// rewriteCacheLinks makes the outer alternate links on the publisher point to
+// the cache.
+func rewriteCacheLinks(values []string, publisherHost string, cacheHost string) []string {
+	links, err := parseLinks(values)
+	if err != nil {
+		return values
+	}
+	var rewritten []string
+	for _, l := range links {
+		if l.hasRel("alternate") {
+			if u, err := url.Parse(l.target); err == nil && u.Host == publisherHost {
+				l.target = cacheURL(cacheHost, u)
+			}
+		}
+		rewritten = append(rewritten, l.String())
+	}
+	return rewritten
+}
+
+func serveFromCache(w http.ResponseWriter, entry *cachedExchange) {
+	for name, values := range entry.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(entry.body)))
+	w.Write(entry.body)
+}
+
+func (s *Server) cacheHandler(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, cachePathPrefix)
+	slash := strings.Index(rest, "/")
+	if slash <= 0 {
+		http.NotFound(w, r)
+		return
+	}
+	publisherURL := &url.URL{
+		Scheme:   "https",
+		Host:     rest[:slash],
+		Path:     rest[slash:],
+		RawQuery: r.URL.RawQuery,
+	}
+
+	if strings.HasPrefix(publisherURL.Path, "/cert/") {
+		rec, err := s.fetchFromPublisher(publisherURL, "application/cert-chain+cbor")
+		if err != nil || rec.Code != http.StatusOK {
+			http.Error(w, "cacheHandler: failed to fetch the certificate", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/cert-chain+cbor")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", cachedCertMaxAge))
+		w.Write(rec.Body.Bytes())
+		return
+	}
+
+	now := s.now()
+	key := publisherURL.String()
+	if entry, ok := s.cache.get(key, now); ok {
+		s.metrics.cacheLookups.inc("hit")
+		serveFromCache(w, entry)
+		return
+	}
+	s.metrics.cacheLookups.inc("miss")
+
+	rec, err := s.fetchFromPublisher(publisherURL, sxgContentType(version.Version1b3))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	e, expires, err := s.validateForCache(rec, now)
+	if err != nil {
+		http.Error(w, "cacheHandler: "+publisherURL.String()+": "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	// Like real caches, serve the certificate from the cache too.
+	e.SignatureHeaderValue = certURLParam.ReplaceAllStringFunc(e.SignatureHeaderValue, func(param string) string {
+		certURL, err := url.Parse(strings.TrimSuffix(strings.TrimPrefix(param, "cert-url=\""), "\""))
+		if err != nil || certURL.Scheme != "https" {
+			return param
+		}
+		return "cert-url=\"" + cacheURL(r.Host, certURL) + "\""
+	})
+	var body bytes.Buffer
+	if err := e.Write(&body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	header := http.Header{}
+	for _, name := range cacheForwardedHeaders {
+		if values := rec.Header()[name]; len(values) > 0 {
+			header[name] = values
+		}
+	}
+	header["Link"] = rewriteCacheLinks(header["Link"], publisherURL.Host, r.Host)
+	if len(header["Link"]) == 0 {
+		delete(header, "Link")
+	}
+	maxAge := cachedExchangeMaxAge
+	if remaining := int(expires.Sub(now).Seconds()); remaining < maxAge {
+		maxAge = remaining
+	}
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	header.Set("X-Content-Type-Options", "nosniff")
+
+	entry := &cachedExchange{header: header, body: body.Bytes(), expires: now.Add(time.Duration(maxAge) * time.Second)}
+	s.cache.put(key, entry, now)
+	serveFromCache(w, entry)
+}
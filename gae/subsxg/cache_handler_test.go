@@ -0,0 +1,73 @@
+package subsxg
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExchangeCacheEviction(t *testing.T) {
+	c := newExchangeCache()
+	now := time.Now()
+	for i := 0; i < maxCachedExchanges+10; i++ {
+		c.put(fmt.Sprint(i), &cachedExchange{expires: now.Add(time.Minute)}, now)
+		if i == 0 {
+			c.put("stale", &cachedExchange{expires: now.Add(time.Second)}, now)
+		}
+		// 0 is used, so it is not the least recently used one.
+		if _, ok := c.get("0", now); !ok {
+			t.Fatalf("0 is evicted at %d", i)
+		}
+	}
+	if c.lru.Len() != maxCachedExchanges || len(c.entries) != maxCachedExchanges {
+		t.Errorf("%d entries", c.lru.Len())
+	}
+	if _, ok := c.get("1", now); ok {
+		t.Error("1 is not evicted")
+	}
+
+	later := now.Add(2 * time.Second)
+	c.put("big", &cachedExchange{body: make([]byte, maxCacheSize-10), expires: later.Add(time.Minute)}, later)
+	c.put("small", &cachedExchange{body: make([]byte, 20), expires: later.Add(time.Minute)}, later)
+	if _, ok := c.get("big", later); ok {
+		t.Error("the cache is larger than maxCacheSize")
+	}
+	if _, ok := c.entries["stale"]; ok {
+		t.Error("the expired entry is not evicted")
+	}
+	if c.size != 20 {
+		t.Errorf("size %d", c.size)
+	}
+}
+
+func TestCacheCertFetchesAreInternal(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...))
+	readExchange(t, get(t, s, "https://"+testHost+cachePathPrefix+testHost+"/sxg/hello.sxg", testAccept))
+	get(t, s, "https://"+testHost+"/cert/cert.cbor", "*/*")
+	metrics := get(t, s, "https://"+testHost+metricsURLPath, "*/*").Body.String()
+	for _, want := range []string{
+		`sxg_cert_fetches_total{identity="default",source="internal"} 1`,
+		`sxg_cert_fetches_total{identity="default",source="client"} 1`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("no %s in\n%s", want, metrics)
+		}
+	}
+}
+
+func TestRewriteCacheLinks(t *testing.T) {
+	got := rewriteCacheLinks([]string{
+		`<https://sxg.test/sxg/a.sxg>;rel=alternate;type="application/signed-exchange;v=b3";anchor="https://demo.test/a.js", <https://sxg.test/sxg/b.sxg>; rel="preload alternate"`,
+		`<https://other.test/sxg/c.sxg>;rel="alternate", <https://sxg.test/d.js>;rel="preload";as="script"`,
+	}, "sxg.test", "cache.test")
+	want := []string{
+		`<https://cache.test/c/s/sxg.test/sxg/a.sxg>;rel="alternate";type="application/signed-exchange;v=b3";anchor="https://demo.test/a.js"`,
+		`<https://cache.test/c/s/sxg.test/sxg/b.sxg>;rel="preload alternate"`,
+		`<https://other.test/sxg/c.sxg>;rel="alternate"`,
+		`<https://sxg.test/d.js>;rel="preload";as="script"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("rewriteCacheLinks = %q, want %q", got, want)
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package subsxg
 
 import (
 	"bytes"
@@ -72,31 +72,25 @@ func getCertMessage(pem []byte) ([]byte, error) {
 	return createCertChainCBOR(certs, ocsp, nil)
 }
 
-func respondWithCertificateMessage(w http.ResponseWriter, r *http.Request, msg []byte) {
+func (s *Server) respondWithCertificateMessage(w http.ResponseWriter, r *http.Request, msg []byte) {
+	s.addReportingHeaders(w, r)
 	w.Header().Set("Content-Type", "application/cert-chain+cbor")
 	w.Header().Set("Cache-Control", "public, max-age=100")
+	w = newShapedWriter(w, shapingFromQuery(r.URL.Query(), "", Shaping{}))
 	w.Write(msg)
 }
 
-func certHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path == certURLPath {
-		respondWithCertificateMessage(w, r, certMessage)
-		return
-	}
-	if r.URL.Path == altCertURLPath {
-		respondWithCertificateMessage(w, r, altCertMessage)
-		return
+func (s *Server) certHandler(w http.ResponseWriter, r *http.Request) {
+	for _, id := range []*Identity{s.defaultIdentity, s.altIdentity} {
+		if r.URL.Path == id.certURLPath {
+			source := "client"
+			if isInternalFetch(r) {
+				source = "internal"
+			}
+			s.metrics.certFetches.inc(id.name, source)
+			s.respondWithCertificateMessage(w, r, id.getCertMessage())
+			return
+		}
 	}
 	http.NotFound(w, r)
 }
-
-func getSubjectCommonName(pem []byte) (string, error) {
-	certs, err := signedexchange.ParseCertificates(pem)
-	if err != nil {
-		return "", err
-	}
-	if len(certs) == 0 {
-		return "", errors.New("Empty certificate")
-	}
-	return certs[0].Subject.CommonName, nil
-}
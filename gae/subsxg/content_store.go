@@ -0,0 +1,160 @@
+package subsxg
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Content is a payload in a ContentStore, keyed by its logical path (e.g.
+// "nikko_320.jpg").
+type Content struct {
+	path        string
+	contentType string
+	payload     []byte
+	// digest is the SHA-256 digest of the payload in the "sha256-<base64>"
+	// form which is used in the integrity attributes.
+	digest string
+}
+
+// A ContentStore holds the payloads which are signed. The stores other than
+// the ones of NewFSContentStore and NewMemContentStore return the contents of
+// NewContent.
+type ContentStore interface {
+	// Get returns the content at path.
+	Get(path string) (*Content, bool)
+	// Paths returns the paths of all the contents, which are listed on the
+	// index page and signed at /sxg/auto/.
+	Paths() []string
+}
+
+// The content types which the signed exchanges have been using. Other types
+// are derived from the file extension or sniffed from the payload.
+var contentTypes = map[string]string{
+	".css":   "text/css",
+	".html":  "text/html; charset=utf-8",
+	".jpg":   "image/jpeg",
+	".js":    "text/javascript",
+	".webp":  "image/webp",
+	".woff2": "font/woff2",
+}
+
+// NewContent returns the content of payload at path p. The content type is
+// derived from the extension of p, or sniffed from the payload.
+func NewContent(p string, payload []byte) *Content {
+	ext := path.Ext(p)
+	contentType, ok := contentTypes[ext]
+	if !ok {
+		contentType = mime.TypeByExtension(ext)
+	}
+	if contentType == "" {
+		contentType = http.DetectContentType(payload)
+	}
+	sum := sha256.Sum256(payload)
+	return &Content{
+		path:        p,
+		contentType: contentType,
+		payload:     payload,
+		digest:      "sha256-" + base64.StdEncoding.EncodeToString(sum[:]),
+	}
+}
+
+// Path returns the logical path of c.
+func (c *Content) Path() string {
+	return c.path
+}
+
+// ContentType returns the content type of c.
+func (c *Content) ContentType() string {
+	return c.contentType
+}
+
+// Payload returns the payload of c, which must not be modified.
+func (c *Content) Payload() []byte {
+	return c.payload
+}
+
+// Digest returns the SHA-256 digest of the payload of c in the
+// "sha256-<base64>" form which is used in the integrity attributes.
+func (c *Content) Digest() string {
+	return c.digest
+}
+
+// fsContentStore reads the payloads from a file system, such as the embedded
+// contents or the local contents/ directory, the first time they are
+// requested, and keeps them.
+type fsContentStore struct {
+	fsys fs.FS
+
+	mu       sync.Mutex
+	contents map[string]*Content
+}
+
+// NewFSContentStore returns a ContentStore which reads the payloads from fsys.
+// A file which changes after it has been read is served as it was.
+func NewFSContentStore(fsys fs.FS) ContentStore {
+	return &fsContentStore{fsys: fsys, contents: map[string]*Content{}}
+}
+
+func (s *fsContentStore) Get(p string) (*Content, bool) {
+	if !fs.ValidPath(p) {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.contents[p]; ok {
+		return c, true
+	}
+	payload, err := fs.ReadFile(s.fsys, p)
+	if err != nil {
+		return nil, false
+	}
+	c := NewContent(p, payload)
+	s.contents[p] = c
+	return c, true
+}
+
+func (s *fsContentStore) Paths() []string {
+	var paths []string
+	fs.WalkDir(s.fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() && !strings.HasPrefix(d.Name(), ".") {
+			paths = append(paths, p)
+		}
+		return nil
+	})
+	return paths
+}
+
+// memContentStore holds the payloads in memory.
+type memContentStore struct {
+	contents map[string]*Content
+}
+
+// NewMemContentStore returns a ContentStore of payloads keyed by path.
+func NewMemContentStore(payloads map[string][]byte) ContentStore {
+	s := &memContentStore{contents: map[string]*Content{}}
+	for p, payload := range payloads {
+		s.contents[p] = NewContent(p, payload)
+	}
+	return s
+}
+
+func (s *memContentStore) Get(p string) (*Content, bool) {
+	c, ok := s.contents[p]
+	return c, ok
+}
+
+func (s *memContentStore) Paths() []string {
+	var paths []string
+	for p := range s.contents {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
@@ -0,0 +1,33 @@
+package subsxg
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFSContentStore(t *testing.T) {
+	fsys := fstest.MapFS{"css/a.css": {Data: []byte("a {}")}}
+	store := NewFSContentStore(fsys)
+	c, ok := store.Get("css/a.css")
+	if !ok {
+		t.Fatal("css/a.css is not found")
+	}
+	sum := sha256.Sum256([]byte("a {}"))
+	if c.Path() != "css/a.css" || c.ContentType() != "text/css" || string(c.Payload()) != "a {}" || c.Digest() != "sha256-"+base64.StdEncoding.EncodeToString(sum[:]) {
+		t.Errorf("content %q %q %q %q", c.Path(), c.ContentType(), c.Payload(), c.Digest())
+	}
+
+	// The payloads are read once.
+	fsys["css/a.css"].Data = []byte("b {}")
+	if again, _ := store.Get("css/a.css"); again != c {
+		t.Error("css/a.css is read again")
+	}
+	if _, ok := store.Get("../a.css"); ok {
+		t.Error("../a.css is found")
+	}
+	if _, ok := store.Get("css/b.css"); ok {
+		t.Error("css/b.css is found")
+	}
+}
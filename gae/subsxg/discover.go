@@ -0,0 +1,247 @@
+package subsxg
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// subresource is a resource referenced from an HTML payload which can be
+// preloaded from a signed exchange.
+type subresource struct {
+	url         *url.URL
+	as          string
+	crossorigin bool
+	// imagesrcset and imagesizes are set for the images which have srcset.
+	imagesrcset string
+	imagesizes  string
+	// candidates are the URLs in imagesrcset.
+	candidates []*url.URL
+}
+
+var (
+	fontFaceRule = regexp.MustCompile(`(?s)@font-face\s*{[^}]*}`)
+	cssURL       = regexp.MustCompile(`url\(\s*['"]?([^'")]+?)['"]?\s*\)`)
+)
+
+// discoverSubresources finds the scripts, stylesheets, images and fonts in the
+// HTML payload and in its inline CSS. Relative URLs are resolved against base.
+func discoverSubresources(payload []byte, base *url.URL) []*subresource {
+	var found []*subresource
+	resolve := func(ref string) *url.URL {
+		u, err := base.Parse(strings.TrimSpace(ref))
+		if err != nil || u.Scheme != "https" {
+			return nil
+		}
+		u.Fragment = ""
+		return u
+	}
+	add := func(ref string, as string, crossorigin bool) {
+		if u := resolve(ref); u != nil {
+			found = append(found, &subresource{url: u, as: as, crossorigin: crossorigin})
+		}
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(payload))
+	inStyle := false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		token := z.Token()
+		switch tt {
+		case html.TextToken:
+			if inStyle {
+				found = append(found, discoverCSSSubresources(token.Data, resolve)...)
+			}
+			continue
+		case html.EndTagToken:
+			if token.Data == "style" {
+				inStyle = false
+			}
+			continue
+		case html.StartTagToken, html.SelfClosingTagToken:
+		default:
+			continue
+		}
+
+		attrs := map[string]string{}
+		for _, a := range token.Attr {
+			attrs[a.Key] = a.Val
+		}
+		_, crossorigin := attrs["crossorigin"]
+		switch token.Data {
+		case "style":
+			inStyle = tt == html.StartTagToken
+		case "script":
+			if src, ok := attrs["src"]; ok {
+				add(src, "script", crossorigin)
+			}
+		case "link":
+			if href, ok := attrs["href"]; ok && strings.EqualFold(attrs["rel"], "stylesheet") {
+				add(href, "style", crossorigin)
+			}
+		case "img", "amp-img":
+			srcset, ok := attrs["srcset"]
+			if !ok {
+				if src, ok := attrs["src"]; ok {
+					add(src, "image", crossorigin)
+				}
+				continue
+			}
+			if r := discoverImageSrcset(attrs["src"], srcset, attrs["sizes"], resolve); r != nil {
+				r.crossorigin = crossorigin
+				found = append(found, r)
+			}
+		}
+	}
+	return found
+}
+
+func discoverCSSSubresources(css string, resolve func(string) *url.URL) []*subresource {
+	var found []*subresource
+	fonts := map[string]bool{}
+	for _, rule := range fontFaceRule.FindAllString(css, -1) {
+		for _, m := range cssURL.FindAllStringSubmatch(rule, -1) {
+			fonts[m[1]] = true
+		}
+	}
+	for _, m := range cssURL.FindAllStringSubmatch(css, -1) {
+		u := resolve(m[1])
+		if u == nil {
+			continue
+		}
+		if fonts[m[1]] {
+			// Fonts are always fetched in CORS mode.
+			found = append(found, &subresource{url: u, as: "font", crossorigin: true})
+		} else {
+			found = append(found, &subresource{url: u, as: "image"})
+		}
+	}
+	return found
+}
+
+func discoverImageSrcset(src, srcset, sizes string, resolve func(string) *url.URL) *subresource {
+	r := &subresource{as: "image", imagesizes: sizes}
+	var candidates []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		fields := strings.Fields(candidate)
+		if len(fields) == 0 {
+			continue
+		}
+		u := resolve(fields[0])
+		if u == nil {
+			continue
+		}
+		r.candidates = append(r.candidates, u)
+		fields[0] = u.String()
+		candidates = append(candidates, strings.Join(fields, " "))
+	}
+	if len(r.candidates) == 0 {
+		return nil
+	}
+	r.imagesrcset = strings.Join(candidates, ", ")
+	r.url = resolve(src)
+	if r.url == nil {
+		r.url = r.candidates[0]
+	}
+	return r
+}
+
+// autoSXGIdentity returns the value of the "identity" query parameter of the
+// auto signed exchanges for the resources on host. Only the resources on
+// demoDomainName and on the configured cross-origin altDemoDomainName can be
+// signed.
+func (s *Server) autoSXGIdentity(host string) (string, bool) {
+	switch host {
+	case s.demoDomainName:
+		return "", true
+	case s.altDemoDomainName:
+		return "alt", true
+	}
+	return "", false
+}
+
+// autoSXGURL returns the URL of the auto signed exchange of u served from
+// host, and the content in the content store which the origin serves for u.
+func (s *Server) autoSXGURL(u *url.URL, host string) (string, *Content, bool) {
+	identity, ok := s.autoSXGIdentity(u.Host)
+	if !ok {
+		return "", nil, false
+	}
+	key, ok := s.contentKey(u.Host, u.Path)
+	if !ok {
+		return "", nil, false
+	}
+	c, ok := s.contents.Get(key)
+	if !ok {
+		return "", nil, false
+	}
+	sxgURL := "https://" + host + autoSXGPathPrefix + c.path + ".sxg"
+	if identity != "" {
+		sxgURL += "?identity=" + identity
+	}
+	return sxgURL, c, true
+}
+
+// addDiscoveredSubresourceLinks adds the outer alternate links, and the inner
+// allowed-alt-sxg and preload links for the subresources of the HTML payload
+// in params which can be served as auto signed exchanges.
+func (s *Server) addDiscoveredSubresourceLinks(params *exchangeParams, r *http.Request) {
+	base, err := url.Parse(params.contentUrl)
+	if err != nil {
+		return
+	}
+	linked := map[string]*Content{}
+	addAlternate := func(u *url.URL) (*Content, bool) {
+		if c, ok := linked[u.String()]; ok {
+			return c, true
+		}
+		sxgURL, c, ok := s.autoSXGURL(u, r.Host)
+		if !ok {
+			return nil, false
+		}
+		linked[u.String()] = c
+		params.outerHeader.Add("link", alternateLink(sxgURL, u.String(), sxgContentType(params.ver)).String())
+		params.resHeader.Add("link", allowedAltSXGLink(u.String(), s.childHeaderIntegrity(r, sxgURL)).String())
+		return c, true
+	}
+
+	preloaded := map[string]bool{}
+	for _, sub := range discoverSubresources(params.payload, base) {
+		urls := sub.candidates
+		if len(urls) == 0 {
+			urls = []*url.URL{sub.url}
+		}
+		var c *Content
+		for _, u := range urls {
+			if found, ok := addAlternate(u); ok && c == nil {
+				c = found
+			}
+		}
+		if c == nil || preloaded[sub.url.String()] {
+			continue
+		}
+		preloaded[sub.url.String()] = true
+
+		preload := preloadLink(sub.url.String(), sub.as)
+		if sub.as == "font" {
+			preload.params = append(preload.params, linkParam{name: "type", value: c.contentType})
+		}
+		if sub.imagesrcset != "" {
+			preload.params = append(preload.params, linkParam{name: "imagesrcset", value: sub.imagesrcset})
+		}
+		if sub.imagesizes != "" {
+			preload.params = append(preload.params, linkParam{name: "imagesizes", value: sub.imagesizes})
+		}
+		if sub.crossorigin {
+			preload.params = append(preload.params, linkParam{name: "crossorigin", bare: true})
+		}
+		params.resHeader.Add("link", preload.String())
+	}
+}
@@ -0,0 +1,56 @@
+package subsxg
+
+import (
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDiscoverSubresources(t *testing.T) {
+	// The font of fonttest.html is on the production alt origin.
+	fonttest, err := fs.ReadFile(os.DirFS("../contents"), "fonttest.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fonttest = []byte(strings.Replace(string(fonttest), "news.horo.jp", testAltDomainName, -1))
+	s := newTestServer(t, WithContentStore(&layeredContentStore{stores: []ContentStore{
+		NewMemContentStore(map[string][]byte{"fonttest.html": fonttest}),
+		NewFSContentStore(os.DirFS("../contents")),
+	}}))
+
+	for name, want := range map[string][]string{
+		"amptestnocdn.html": {
+			"https://" + testDomainName + "/amptest/img/nikko_320.jpg",
+			"https://" + testDomainName + "/amptest/img/nikko_640.jpg",
+			"https://" + testDomainName + "/amptest/js/v0.js",
+		},
+		"fonttest.html": {
+			"https://" + testAltDomainName + "/fonts/wapuro-mincho.woff2",
+		},
+	} {
+		sxgPath := autoSXGPathPrefix + name + ".sxg"
+		rec := get(t, s, "https://"+testHost+sxgPath, testAccept)
+		e := readExchange(t, rec)
+		if problems := lintLinks(rec.Header()["Link"], e.ResponseHeaders["Link"]); len(problems) > 0 {
+			t.Errorf("%s: lint: %s", name, strings.Join(problems, "; "))
+		}
+		checkAlternates(t, s, sxgPath, rec.Header()["Link"], e.ResponseHeaders["Link"])
+
+		links, err := parseLinks(rec.Header()["Link"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range links {
+			if l.hasRel("alternate") {
+				got = append(got, l.get("anchor"))
+			}
+		}
+		sort.Strings(got)
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: the alternates of %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,336 @@
+package subsxg
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+	"golang.org/x/crypto/ocsp"
+)
+
+const (
+	testHost          = "sxg.test"
+	testDomainName    = "demo.test"
+	testAltDomainName = "alt.test"
+	testAccept        = "application/signed-exchange;v=b3;q=0.9,*/*;q=0.8"
+)
+
+func newTestIdentity(t *testing.T, name string, domainName string, certURLPath string, ca *x509.Certificate, caKey crypto.Signer) *Identity {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(now.UnixNano()),
+		Subject:      pkix.Name{CommonName: domainName},
+		DNSNames:     []string{domainName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(90 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{
+			{Id: canSignHttpExchangesOID, Value: asn1.NullBytes},
+		},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetchOCSP := func(certs []*x509.Certificate) ([]byte, error) {
+		return ocsp.CreateResponse(certs[1], certs[1], ocsp.Response{
+			Status:       ocsp.Good,
+			SerialNumber: certs[0].SerialNumber,
+			ThisUpdate:   now.Add(-time.Hour),
+			NextUpdate:   now.Add(7 * 24 * time.Hour),
+		}, caKey)
+	}
+	id, err := NewIdentity(name, []*x509.Certificate{cert, ca}, key, certURLPath, fetchOCSP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// newTestServer returns a Server with the identities for testDomainName and
+// testAltDomainName, which are issued by a throwaway CA.
+func newTestServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "sub-sxg test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts = append([]Option{
+		WithIdentity(newTestIdentity(t, "default", testDomainName, "/cert/cert.cbor", ca, caKey)),
+		WithAltIdentity(newTestIdentity(t, "alt", testAltDomainName, "/cert/alt_cert.cbor", ca, caKey)),
+		WithContentStore(NewFSContentStore(os.DirFS("../contents"))),
+		WithLogOutput(ioutil.Discard),
+	}, opts...)
+	s, err := New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// unshapedScenarios returns DefaultScenarios without the shapings, which
+// would only make the tests slow.
+func unshapedScenarios() []Scenario {
+	scenarios := DefaultScenarios()
+	for i := range scenarios {
+		scenarios[i].Shaping = Shaping{}
+		scenarios[i].SubresourceShaping = Shaping{}
+		scenarios[i].CertShaping = Shaping{}
+	}
+	return scenarios
+}
+
+// get runs s for rawURL, following the redirects.
+func get(t *testing.T, s *Server, rawURL string, accept string) *httptest.ResponseRecorder {
+	t.Helper()
+	for i := 0; i < 5; i++ {
+		req := httptest.NewRequest(http.MethodGet, rawURL, nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusFound {
+			return rec
+		}
+		rawURL = rec.Header().Get("Location")
+	}
+	t.Fatalf("too many redirects: %s", rawURL)
+	return nil
+}
+
+func readExchange(t *testing.T, rec *httptest.ResponseRecorder) *signedexchange.Exchange {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/signed-exchange;v=b3" {
+		t.Fatalf("content type %q", ct)
+	}
+	e, err := signedexchange.ReadExchange(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func headerIntegrityOf(t *testing.T, e *signedexchange.Exchange) string {
+	t.Helper()
+	integrity, err := headerIntegrity(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return integrity
+}
+
+// scenarioPaths returns the /sxg/ paths of the scenarios and of the contents.
+func scenarioPaths(s *Server) []string {
+	var paths []string
+	for _, scenario := range s.scenarios {
+		paths = append(paths, "/sxg/"+scenario.Name)
+	}
+	for _, p := range s.contents.Paths() {
+		paths = append(paths, autoSXGPathPrefix+p+".sxg")
+	}
+	return paths
+}
+
+func TestScenarios(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...))
+	for _, path := range scenarioPaths(s) {
+		path := path
+		name := strings.TrimPrefix(path, "/sxg/")
+		t.Run(name, func(t *testing.T) {
+			rec := get(t, s, "https://"+testHost+path, testAccept)
+			e := readExchange(t, rec)
+
+			var logBuf bytes.Buffer
+			payload, ok := e.Verify(time.Now(), s.fetchCertForVerification, log.New(&logBuf, "", 0))
+			switch {
+			case strings.HasPrefix(name, "forbidden_") || name == "v0_set_cookie.sxg":
+				if ok {
+					t.Errorf("the exchange with a forbidden header was verified")
+				}
+				return
+			}
+			if !ok {
+				t.Fatalf("verification failed: %s", logBuf.String())
+			}
+			if strings.HasPrefix(name, "status_") {
+				if e.ResponseStatus == http.StatusOK {
+					t.Errorf("inner status is 200")
+				}
+				return
+			}
+
+			u, err := url.Parse(e.RequestURI)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.Scheme != "https" || (u.Host != testDomainName && u.Host != testAltDomainName) {
+				t.Errorf("content URL %s is not on the identities", e.RequestURI)
+			}
+			if c, ok := s.originContent(u.Host, u.Path); ok && !strings.Contains(name, "nosniffable") && !strings.HasSuffix(name, "_webp.sxg") {
+				if !bytes.Equal(payload, c.payload) {
+					t.Errorf("payload of %s is not the content served there", e.RequestURI)
+				}
+			}
+
+			if problems := lintLinks(rec.Header()["Link"], e.ResponseHeaders["Link"]); len(problems) > 0 {
+				t.Errorf("lint: %s", strings.Join(problems, "; "))
+			}
+			checkAlternates(t, s, name, rec.Header()["Link"], e.ResponseHeaders["Link"])
+		})
+	}
+}
+
+// The scenarios which link to each other. The header-integrity values can't
+// match, since they would depend on themselves. loop.sxg only enters the
+// cycle, so its link to a_css.sxg matches.
+var loopScenarios = map[string]bool{
+	"a_css.sxg": true,
+	"b_css.sxg": true,
+}
+
+// checkAlternates checks the header-integrity of the allowed-alt-sxg links
+// against the alternate exchanges. The integrity of the subresources of the
+// _error scenarios is deliberately wrong.
+func checkAlternates(t *testing.T, s *Server, name string, outerLinks []string, innerLinks []string) {
+	t.Helper()
+	inner, err := parseLinks(innerLinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed := map[string]string{}
+	for _, l := range inner {
+		if l.hasRel("allowed-alt-sxg") {
+			allowed[l.target+" "+l.get(variantKeyParam)] = l.get("header-integrity")
+		}
+	}
+
+	outer, err := parseLinks(outerLinks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mismatches := 0
+	for _, l := range outer {
+		if !l.hasRel("alternate") {
+			continue
+		}
+		anchor := l.get("anchor")
+		want, ok := allowed[anchor+" "+l.get(variantKeyParam)]
+		if !ok {
+			t.Errorf("no allowed-alt-sxg for %s", anchor)
+			continue
+		}
+		child := readExchange(t, get(t, s, l.target, testAccept))
+		if child.RequestURI != anchor {
+			t.Errorf("alternate %s signs %s, not %s", l.target, child.RequestURI, anchor)
+		}
+		if got := headerIntegrityOf(t, child); got != want {
+			mismatches++
+			if !strings.HasSuffix(name, "_error.sxg") && !loopScenarios[name] {
+				t.Errorf("header-integrity of %s is %s, want %s", l.target, got, want)
+			}
+		}
+	}
+	if strings.HasSuffix(name, "_error.sxg") && mismatches == 0 {
+		t.Errorf("all the header-integrity values of the error scenario match")
+	}
+}
+
+func TestInspect(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...))
+	rec := get(t, s, "https://"+testHost+inspectPathPrefix+"amptestnocdn_js_img_preload.sxg?format=json", "*/*")
+	var in inspection
+	if err := json.Unmarshal(rec.Body.Bytes(), &in); err != nil {
+		t.Fatal(err)
+	}
+	if !in.Verified || in.Error != "" {
+		t.Errorf("inspection failed: %s %s", in.Error, in.VerifyLog)
+	}
+	if in.ContentURL != "https://"+testDomainName+"/amptest/amptestnocdn.html" {
+		t.Errorf("content URL %s", in.ContentURL)
+	}
+	if len(in.InnerLinks) == 0 || len(in.LinkProblems) != 0 {
+		t.Errorf("links %q, problems %q", in.InnerLinks, in.LinkProblems)
+	}
+}
+
+// TestScenarioExpectations checks that the expectations of each scenario
+// cover exactly its alternate exchanges.
+func TestScenarioExpectations(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...))
+	for _, scenario := range s.scenarios {
+		req := httptest.NewRequest(http.MethodGet, "https://"+testHost+"/sxg/"+scenario.Name, nil)
+		req.Header.Set("Accept", testAccept)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		links, err := parseLinks(rec.Header()["Link"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var alternates []string
+		for _, l := range links {
+			if l.hasRel("alternate") {
+				u, err := url.Parse(l.target)
+				if err != nil {
+					t.Fatal(err)
+				}
+				alternates = append(alternates, strings.TrimPrefix(u.Path, "/sxg/"))
+			}
+		}
+		sort.Strings(alternates)
+		if names := scenario.subresourceNames(); strings.Join(names, " ") != strings.Join(alternates, " ") {
+			t.Errorf("%s: expectations of %q, alternates %q", scenario.Name, names, alternates)
+		}
+		fallback := strings.HasPrefix(scenario.Name, "forbidden_") || strings.HasPrefix(scenario.Name, "status_") || scenario.Name == "v0_set_cookie.sxg"
+		if fallback != (scenario.outcome() == OutcomeFallback) {
+			t.Errorf("%s: outcome %s", scenario.Name, scenario.outcome())
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package subsxg
+
+import (
+	"sort"
+	"strings"
+)
+
+// An Outcome is what the browser is expected to do with a signed exchange.
+type Outcome string
+
+const (
+	// The browser uses the response in the exchange. It is the default.
+	OutcomeSXG Outcome = "sxg"
+	// The browser rejects the exchange and fetches the content URL.
+	OutcomeFallback Outcome = "fallback"
+	// The browser uses the exchange, but CORB blocks the response of the
+	// cross-origin subresource.
+	OutcomeCORBBlocked Outcome = "corb-blocked"
+	// The browser uses the exchange, but the CORS check of the cross-origin
+	// subresource fails.
+	OutcomeCORSBlocked Outcome = "cors-blocked"
+	// The browser uses another variant of the subresource.
+	OutcomeUnused Outcome = "unused"
+)
+
+// outcome returns the Outcome of the exchange of sc.
+func (sc *Scenario) outcome() Outcome {
+	if sc.Outcome == "" {
+		return OutcomeSXG
+	}
+	return sc.Outcome
+}
+
+// subresourceNames returns the names of the alternate exchanges of sc in
+// order.
+func (sc *Scenario) subresourceNames() []string {
+	var names []string
+	for name := range sc.Subresources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// expectationSummary describes the outcomes of sc on the index page.
+func (sc *Scenario) expectationSummary() string {
+	s := []string{"exchange: " + string(sc.outcome())}
+	for _, name := range sc.subresourceNames() {
+		s = append(s, name+": "+string(sc.Subresources[name]))
+	}
+	return strings.Join(s, "; ")
+}
@@ -0,0 +1,87 @@
+package subsxg
+
+import (
+	"net/http"
+	"strings"
+)
+
+// The hop-by-hop and the stateful headers which the browsers reject in the
+// inner responses, with values which would otherwise be valid.
+var forbiddenInnerHeaders = []struct {
+	name  string
+	value string
+}{
+	{"Connection", "close"},
+	{"Keep-Alive", "timeout=5"},
+	{"Proxy-Connection", "keep-alive"},
+	{"Trailer", "Expires"},
+	{"Transfer-Encoding", "chunked"},
+	{"Upgrade", "websocket"},
+	{"Authentication-Control", "sxg-test"},
+	{"Authentication-Info", `nextnonce="sxg-test"`},
+	{"Clear-Site-Data", `"cookies"`},
+	{"Optional-WWW-Authenticate", `Basic realm="sub-sxg"`},
+	{"Proxy-Authenticate", `Basic realm="sub-sxg"`},
+	{"Proxy-Authentication-Info", `nextnonce="sxg-test"`},
+	{"Public-Key-Pins", `pin-sha256="d6qzRu9zOECb90Uez27xWltNsj0e1Md7GkYYkVoZWmM="; max-age=0`},
+	{"Sec-WebSocket-Accept", "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="},
+	{"Set-Cookie", "sxg-test=1"},
+	{"Set-Cookie2", "sxg-test=1"},
+	{"SetProfile", "sxg-test"},
+	{"Strict-Transport-Security", "max-age=0"},
+	{"WWW-Authenticate", `Basic realm="sub-sxg"`},
+}
+
+// forbiddenHeaderScenarios returns the negative tests of the header rules:
+// forbidden_<header>.sxg signs hello.html with the header, and
+// amptestnocdn_js_set_cookie_preload.sxg links to the exchange of v0.js which
+// has the Set-Cookie header while the page itself is valid.
+func forbiddenHeaderScenarios() []Scenario {
+	var scenarios []Scenario
+	for _, h := range forbiddenInnerHeaders {
+		h := h
+		scenarios = append(scenarios, Scenario{
+			Name:     "forbidden_" + strings.ReplaceAll(strings.ToLower(h.name), "-", "_") + ".sxg",
+			Listed:   true,
+			Expected: "The browser rejects the exchange because of the " + h.name + " header, and falls back to the content URL.",
+			Outcome:  OutcomeFallback,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.permissiveHeaders = true
+				params.resHeader.Add(h.name, h.value)
+			},
+		})
+	}
+
+	return append(scenarios,
+		Scenario{
+			Name:     "amptestnocdn_js_set_cookie_preload.sxg",
+			Listed:   true,
+			Expected: "The page is served from the exchange. The browser rejects the exchange of v0.js because of the Set-Cookie header, and fetches v0.js from the content URL.",
+			Subresources: map[string]Outcome{
+				"v0_set_cookie.sxg": OutcomeFallback,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				v0URL := "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				params.outerHeader.Add("link", alternateLink("https://"+r.Host+"/sxg/v0_set_cookie.sxg", v0URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(v0URL, s.childHeaderIntegrity(r, "https://"+r.Host+"/sxg/v0_set_cookie.sxg")).String())
+				params.resHeader.Add("link", preloadLink(v0URL, "script").String())
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		Scenario{
+			Name:    "v0_set_cookie.sxg",
+			Listed:  false,
+			Outcome: OutcomeFallback,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.permissiveHeaders = true
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				params.contentType = "text/javascript"
+				params.payload = s.contentPayload("v0.js")
+				params.resHeader.Add("set-cookie", "sxg-test=1")
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.outerHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+	)
+}
@@ -0,0 +1,316 @@
+package subsxg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+// The fetches of the exchange served at /sxg/<name> are exported as a HAR
+// file at harPathPrefix + <name>. The query is passed through.
+const harPathPrefix = "/har/"
+
+// The maximum number of the entries of a HAR file. The alternate exchanges
+// are followed until the limit.
+const maxHAREntries = 100
+
+// The HAR 1.2 format. The decoded exchanges are in the custom
+// _signedExchange field of the entries.
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+	Comment string     `json:"comment,omitempty"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harEntry struct {
+	StartedDateTime string             `json:"startedDateTime"`
+	Time            float64            `json:"time"`
+	Request         harRequest         `json:"request"`
+	Response        harResponse        `json:"response"`
+	Cache           struct{}           `json:"cache"`
+	Timings         harTimings         `json:"timings"`
+	SignedExchange  *harSignedExchange `json:"_signedExchange,omitempty"`
+	Comment         string             `json:"comment,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// harSignedExchange is the exchange decoded from the response body of an
+// entry.
+type harSignedExchange struct {
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Signature       string      `json:"signature"`
+	HeaderIntegrity string      `json:"headerIntegrity,omitempty"`
+	Verified        bool        `json:"verified"`
+	VerifyLog       string      `json:"verifyLog,omitempty"`
+	Error           string      `json:"error,omitempty"`
+}
+
+func harHeaders(h http.Header) []harNameValue {
+	headers := []harNameValue{}
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, value := range h[name] {
+			headers = append(headers, harNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+func newHARRequest(u *url.URL, header http.Header) harRequest {
+	query := []harNameValue{}
+	for name, values := range u.Query() {
+		for _, value := range values {
+			query = append(query, harNameValue{Name: name, Value: value})
+		}
+	}
+	sort.SliceStable(query, func(i, j int) bool { return query[i].Name < query[j].Name })
+	return harRequest{
+		Method:      http.MethodGet,
+		URL:         u.String(),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(header),
+		QueryString: query,
+		HeadersSize: -1,
+		BodySize:    0,
+	}
+}
+
+// newHARContent keeps the textual bodies as they are, and encodes the others
+// in base64.
+func newHARContent(body []byte, mimeType string) harContent {
+	content := harContent{Size: len(body), MimeType: mimeType}
+	if len(body) == 0 {
+		return content
+	}
+	mt := strings.ToLower(mimeType)
+	textual := strings.HasPrefix(mt, "text/") || strings.Contains(mt, "javascript") || strings.Contains(mt, "json") || strings.Contains(mt, "xml")
+	if textual && utf8.Valid(body) {
+		content.Text = string(body)
+	} else {
+		content.Text = base64.StdEncoding.EncodeToString(body)
+		content.Encoding = "base64"
+	}
+	return content
+}
+
+func newHARResponse(status int, header http.Header, body []byte) harResponse {
+	return harResponse{
+		Status:      status,
+		StatusText:  http.StatusText(status),
+		HTTPVersion: "HTTP/1.1",
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(header),
+		Content:     newHARContent(body, header.Get("Content-Type")),
+		RedirectURL: header.Get("Location"),
+		HeadersSize: -1,
+		BodySize:    len(body),
+	}
+}
+
+// harRecorder collects the entries of a HAR file, fetching each URL once.
+type harRecorder struct {
+	s       *Server
+	host    string
+	fetched map[string]bool
+	entries []harEntry
+	skipped []string
+}
+
+// fetch fetches u from the handlers and appends the entry. The signed
+// exchanges are decoded, and their cert-chains and alternate exchanges are
+// fetched too.
+func (h *harRecorder) fetch(u *url.URL, accept string) {
+	if h.fetched[u.String()] {
+		return
+	}
+	if u.Host != h.host || len(h.entries) >= maxHAREntries {
+		h.skipped = append(h.skipped, u.String())
+		return
+	}
+	h.fetched[u.String()] = true
+
+	start := time.Now()
+	rec, err := h.s.fetchFromPublisher(u, accept)
+	if err != nil {
+		h.skipped = append(h.skipped, u.String())
+		return
+	}
+	elapsed := float64(time.Since(start)) / float64(time.Millisecond)
+	reqHeader := http.Header{}
+	reqHeader.Set("Accept", accept)
+	reqHeader.Set("User-Agent", cacheEmulatorUserAgent)
+	entry := harEntry{
+		StartedDateTime: start.UTC().Format(time.RFC3339Nano),
+		Time:            elapsed,
+		Request:         newHARRequest(u, reqHeader),
+		Response:        newHARResponse(rec.Code, rec.Header(), rec.Body.Bytes()),
+		Timings:         harTimings{Wait: elapsed},
+	}
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), signedExchangeMIMEType) {
+		h.entries = append(h.entries, entry)
+		return
+	}
+
+	e, err := signedexchange.ReadExchange(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		entry.Comment = "not a valid signed exchange: " + err.Error()
+		h.entries = append(h.entries, entry)
+		return
+	}
+	entry.SignedExchange = h.decode(e)
+	h.entries = append(h.entries, entry)
+
+	if params, err := signatureParams(e); err == nil {
+		if certURL, ok := params["cert-url"].(string); ok && !strings.HasPrefix(certURL, "data:") {
+			if cu, err := url.Parse(certURL); err == nil {
+				h.fetch(cu, "application/cert-chain+cbor")
+			}
+		}
+	}
+	links, err := parseLinks(rec.Header()["Link"])
+	if err != nil {
+		return
+	}
+	for _, l := range links {
+		if !l.hasRel("alternate") || !strings.HasPrefix(l.get("type"), signedExchangeMIMEType) {
+			continue
+		}
+		if au, err := u.Parse(l.target); err == nil {
+			h.fetch(au, sxgContentType(version.Version1b3))
+		}
+	}
+}
+
+// decode returns the inner request and response of e. The payload is the
+// verified one, or the mi-sha256 encoded one if the verification fails.
+func (h *harRecorder) decode(e *signedexchange.Exchange) *harSignedExchange {
+	x := &harSignedExchange{Signature: e.SignatureHeaderValue}
+	var err error
+	if x.HeaderIntegrity, err = headerIntegrity(e); err != nil {
+		x.Error = err.Error()
+	}
+	var verifyLog bytes.Buffer
+	payload, ok := e.Verify(h.s.now(), h.s.fetchCertForVerification, log.New(&verifyLog, "", 0))
+	x.Verified = ok
+	x.VerifyLog = verifyLog.String()
+
+	u, err := url.Parse(e.RequestURI)
+	if err != nil {
+		u = &url.URL{Opaque: e.RequestURI}
+	}
+	x.Request = newHARRequest(u, e.RequestHeaders)
+	if ok {
+		x.Response = newHARResponse(e.ResponseStatus, e.ResponseHeaders, payload)
+	} else {
+		x.Response = newHARResponse(e.ResponseStatus, e.ResponseHeaders, nil)
+		x.Response.Content = newHARContent(e.Payload, "")
+		x.Response.Content.Comment = "the payload is not verified, and is left encoded"
+		x.Response.BodySize = len(e.Payload)
+	}
+	return x
+}
+
+// har fetches u and everything it references, and returns them as a HAR
+// file.
+func (s *Server) har(u *url.URL) *harFile {
+	h := &harRecorder{s: s, host: u.Host, fetched: map[string]bool{}}
+	h.fetch(u, sxgContentType(version.Version1b3))
+	f := &harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "sub-sxg", Version: "1"},
+		Entries: h.entries,
+	}}
+	if f.Log.Entries == nil {
+		f.Log.Entries = []harEntry{}
+	}
+	if len(h.skipped) > 0 {
+		f.Log.Comment = fmt.Sprintf("not fetched: %s", strings.Join(h.skipped, " "))
+	}
+	return f
+}
+
+func (s *Server) harHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	name := strings.TrimPrefix(r.URL.Path, harPathPrefix)
+	if name == "" || strings.Contains(name, "\"") {
+		http.NotFound(w, r)
+		return
+	}
+	u := &url.URL{Scheme: "https", Host: r.Host, Path: "/sxg/" + name, RawQuery: r.URL.RawQuery}
+	f := s.har(u)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.har\"", strings.ReplaceAll(name, "/", "_")))
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(f)
+}
@@ -0,0 +1,80 @@
+package subsxg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestHAR(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...))
+	rec := get(t, s, "https://"+testHost+harPathPrefix+"amptestnocdn_js_img_preload.sxg", "*/*")
+	if cd := rec.Header().Get("Content-Disposition"); !strings.Contains(cd, "amptestnocdn_js_img_preload.sxg.har") {
+		t.Errorf("Content-Disposition %q", cd)
+	}
+	var f harFile
+	if err := json.Unmarshal(rec.Body.Bytes(), &f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Log.Entries) == 0 || f.Log.Comment != "" {
+		t.Fatalf("%d entries, comment %q", len(f.Log.Entries), f.Log.Comment)
+	}
+
+	main := f.Log.Entries[0]
+	if main.Request.URL != "https://"+testHost+"/sxg/amptestnocdn_js_img_preload.sxg" {
+		t.Errorf("the first entry is %s", main.Request.URL)
+	}
+	body, err := base64.StdEncoding.DecodeString(main.Response.Content.Text)
+	if err != nil || main.Response.Content.Encoding != "base64" || !strings.HasPrefix(string(body), "sxg1-b3") {
+		t.Errorf("the .sxg body is not kept: %v", err)
+	}
+
+	var certs, exchanges int
+	for _, entry := range f.Log.Entries {
+		switch {
+		case entry.SignedExchange != nil:
+			exchanges++
+			x := entry.SignedExchange
+			if !x.Verified || x.Error != "" || x.HeaderIntegrity == "" {
+				t.Errorf("%s: %s %s", entry.Request.URL, x.Error, x.VerifyLog)
+			}
+			if x.Response.Content.Size == 0 {
+				t.Errorf("%s: the inner response has no content", entry.Request.URL)
+			}
+		case strings.HasPrefix(entry.Request.URL, "https://"+testHost+"/cert/"):
+			certs++
+		default:
+			t.Errorf("unexpected entry %s", entry.Request.URL)
+		}
+	}
+	if certs != 1 {
+		t.Errorf("%d cert-chain entries, want 1", certs)
+	}
+	links, err := parseLinks(headerValues(main.Response.Headers, "Link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alternates := 0
+	for _, l := range links {
+		if l.hasRel("alternate") {
+			alternates++
+		}
+	}
+	if alternates == 0 || exchanges != alternates+1 {
+		t.Errorf("%d exchanges for %d alternates", exchanges, alternates)
+	}
+	if inner := main.SignedExchange.Request.URL; inner != "https://"+testDomainName+"/amptest/amptestnocdn.html" {
+		t.Errorf("the inner request URL is %s", inner)
+	}
+}
+
+func headerValues(headers []harNameValue, name string) []string {
+	var values []string
+	for _, h := range headers {
+		if h.Name == name {
+			values = append(values, h.Value)
+		}
+	}
+	return values
+}
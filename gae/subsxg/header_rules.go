@@ -0,0 +1,125 @@
+package subsxg
+
+import (
+	"net/http"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+)
+
+// The rules of the inner response headers which the browsers enforce.
+const (
+	ruleInvalidName     = "invalid-name"
+	ruleHopByHop        = "hop-by-hop"
+	ruleConnectionField = "listed-in-connection"
+	ruleStateful        = "stateful"
+	ruleNoCacheField    = "listed-in-no-cache"
+)
+
+var hopByHopHeaders = map[string]bool{
+	"connection":        true,
+	"keep-alive":        true,
+	"proxy-connection":  true,
+	"trailer":           true,
+	"transfer-encoding": true,
+	"upgrade":           true,
+}
+
+// headerViolation is an inner response header which breaks a rule.
+type headerViolation struct {
+	Name string `json:"name"`
+	Rule string `json:"rule"`
+}
+
+func (v headerViolation) String() string {
+	return strings.ToLower(v.Name) + " (" + v.Rule + ")"
+}
+
+type headerRuleError struct {
+	violations []headerViolation
+}
+
+func (e *headerRuleError) Error() string {
+	var s []string
+	for _, v := range e.violations {
+		s = append(s, v.String())
+	}
+	return "inner response headers break the signing rules: " + strings.Join(s, ", ")
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c <= ' ' || c >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// listedFields returns the lower-cased field names in the Connection header
+// values and in the no-cache directives of the Cache-Control header values.
+func listedFields(h http.Header) (connection map[string]bool, noCache map[string]bool) {
+	connection = map[string]bool{}
+	for _, value := range h["Connection"] {
+		for _, name := range strings.Split(value, ",") {
+			connection[strings.ToLower(strings.TrimSpace(name))] = true
+		}
+	}
+	noCache = map[string]bool{}
+	for _, value := range h["Cache-Control"] {
+		for _, directive := range strings.Split(value, ",") {
+			directive = strings.TrimSpace(directive)
+			if !strings.HasPrefix(strings.ToLower(directive), "no-cache=") {
+				continue
+			}
+			fields := strings.Trim(directive[len("no-cache="):], `"`)
+			for _, name := range strings.Split(fields, ",") {
+				noCache[strings.ToLower(strings.TrimSpace(name))] = true
+			}
+		}
+	}
+	return connection, noCache
+}
+
+// sanitizeHeaders normalizes the header names of h, merging the values of the
+// names which differ only in case or surrounding spaces, and returns the
+// headers which break the rules of the inner responses, sorted by name.
+func sanitizeHeaders(h http.Header) (http.Header, []headerViolation) {
+	normalized := http.Header{}
+	for name, values := range h {
+		name = strings.TrimSpace(name)
+		if isToken(name) {
+			name = textproto.CanonicalMIMEHeaderKey(name)
+		}
+		normalized[name] = append(normalized[name], values...)
+	}
+
+	connection, noCache := listedFields(normalized)
+	var violations []headerViolation
+	for name := range normalized {
+		lower := strings.ToLower(name)
+		rule := ""
+		switch {
+		case !isToken(name):
+			rule = ruleInvalidName
+		case hopByHopHeaders[lower]:
+			rule = ruleHopByHop
+		case connection[lower]:
+			rule = ruleConnectionField
+		case signedexchange.IsUncachedHeader(lower):
+			rule = ruleStateful
+		case noCache[lower]:
+			rule = ruleNoCacheField
+		}
+		if rule != "" {
+			violations = append(violations, headerViolation{Name: name, Rule: rule})
+		}
+	}
+	sort.Slice(violations, func(i, j int) bool { return violations[i].Name < violations[j].Name })
+	return normalized, violations
+}
@@ -0,0 +1,157 @@
+package subsxg
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+	"golang.org/x/crypto/ocsp"
+)
+
+const ocspRefreshInterval = time.Hour
+
+// An Identity is a certificate and a private key which the exchanges are
+// signed with, and the cert-chain served at certURLPath.
+type Identity struct {
+	name        string
+	domainName  string
+	certURLPath string
+	certs       []*x509.Certificate
+	prvKey      crypto.PrivateKey
+	fetchOCSP   OCSPFetcher
+
+	mu           sync.Mutex
+	ocspOK       int
+	ocspErrors   int
+	ocsp         []byte
+	ocspErr      error
+	ocspResponse *ocsp.Response
+	certMessage  []byte
+	lastErr      error
+	lastErrTime  time.Time
+}
+
+// An OCSPFetcher returns the OCSP response for certs[0] issued by certs[1].
+type OCSPFetcher func(certs []*x509.Certificate) ([]byte, error)
+
+// LoadIdentity reads the private key and the certificate chain from the PEM
+// files. The OCSP response is fetched from the responder of the certificate.
+func LoadIdentity(name string, keyFileName string, pemFileName string, certURLPath string) (*Identity, error) {
+	certKeyPem, err := ioutil.ReadFile(keyFileName)
+	if err != nil {
+		return nil, err
+	}
+	decodedCertKey, _ := pem.Decode(certKeyPem)
+	if decodedCertKey == nil {
+		return nil, errors.New("no PEM data in " + keyFileName)
+	}
+	prvKey, err := signedexchange.ParsePrivateKey(decodedCertKey.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	certPem, err := ioutil.ReadFile(pemFileName)
+	if err != nil {
+		return nil, err
+	}
+	certs, err := signedexchange.ParseCertificates(certPem)
+	if err != nil {
+		return nil, err
+	}
+	return NewIdentity(name, certs, prvKey, certURLPath, nil)
+}
+
+// NewIdentity returns an Identity which serves the cert-chain of certs at
+// certURLPath. The domain name is the subject common name of certs[0]. A nil
+// fetchOCSP fetches from the responder of the certificate.
+func NewIdentity(name string, certs []*x509.Certificate, prvKey crypto.PrivateKey, certURLPath string, fetchOCSP OCSPFetcher) (*Identity, error) {
+	if len(certs) == 0 {
+		return nil, errors.New("Empty certificate")
+	}
+	if fetchOCSP == nil {
+		fetchOCSP = getOCSP
+	}
+	id := &Identity{
+		name:        name,
+		domainName:  certs[0].Subject.CommonName,
+		certURLPath: certURLPath,
+		certs:       certs,
+		prvKey:      prvKey,
+		fetchOCSP:   fetchOCSP,
+	}
+	id.refreshOCSP()
+	return id, nil
+}
+
+// DomainName returns the domain name which the identity signs for.
+func (id *Identity) DomainName() string {
+	return id.domainName
+}
+
+// refreshOCSP fetches a new OCSP response and rebuilds the cert-chain.
+func (id *Identity) refreshOCSP() {
+	raw, err := id.fetchOCSP(id.certs)
+	var resp *ocsp.Response
+	var certMessage []byte
+	if err == nil && len(id.certs) < 2 {
+		err = errors.New("no issuer certificate")
+	}
+	if err == nil {
+		resp, err = ocsp.ParseResponse(raw, id.certs[1])
+	}
+	if err == nil {
+		certMessage, err = createCertChainCBOR(id.certs, raw, nil)
+	}
+
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.ocspErr = err
+	if err != nil {
+		id.ocspErrors++
+		log.Printf("Failed to refresh OCSP of %s: %v", id.name, err)
+		return
+	}
+	id.ocspOK++
+	id.ocsp = raw
+	id.ocspResponse = resp
+	id.certMessage = certMessage
+}
+
+// needsOCSPRefresh reports whether the OCSP response is missing or is past
+// the half of its validity period.
+func (id *Identity) needsOCSPRefresh(now time.Time) bool {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if id.ocspResponse == nil {
+		return true
+	}
+	thisUpdate := id.ocspResponse.ThisUpdate
+	nextUpdate := id.ocspResponse.NextUpdate
+	return now.After(thisUpdate.Add(nextUpdate.Sub(thisUpdate) / 2))
+}
+
+func (id *Identity) getCertMessage() []byte {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	return id.certMessage
+}
+
+func (id *Identity) recordSigningError(err error, now time.Time) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.lastErr = err
+	id.lastErrTime = now
+}
+
+func (id *Identity) certMessageHash() string {
+	sum := sha256.Sum256(id.getCertMessage())
+	return hex.EncodeToString(sum[:])
+}
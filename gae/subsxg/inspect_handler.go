@@ -0,0 +1,108 @@
+package subsxg
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+// The exchange served at /sxg/<name> is inspected at inspectPathPrefix +
+// <name>. The query is passed through, and "format=json" returns the
+// inspection as JSON.
+const inspectPathPrefix = "/inspect/"
+
+type inspection struct {
+	URL             string      `json:"url"`
+	OuterStatus     int         `json:"outer_status"`
+	OuterHeaders    http.Header `json:"outer_headers"`
+	ContentURL      string      `json:"content_url,omitempty"`
+	Status          int         `json:"status,omitempty"`
+	Headers         http.Header `json:"headers,omitempty"`
+	Signature       string      `json:"signature,omitempty"`
+	HeaderIntegrity string      `json:"header_integrity,omitempty"`
+	PayloadSize     int         `json:"payload_size"`
+	Verified        bool        `json:"verified"`
+	VerifyLog       string      `json:"verify_log,omitempty"`
+	Error           string      `json:"error,omitempty"`
+	LinkProblems    []string    `json:"link_problems"`
+	OuterLinks      []string    `json:"outer_links,omitempty"`
+	InnerLinks      []string    `json:"inner_links,omitempty"`
+}
+
+// inspect fetches u from the handlers, parses the response as a signed
+// exchange, verifies it and lints its links.
+func (s *Server) inspect(u *url.URL) *inspection {
+	in := &inspection{URL: u.String(), LinkProblems: []string{}}
+	rec, err := s.fetchFromPublisher(u, sxgContentType(version.Version1b3))
+	if err != nil {
+		in.Error = err.Error()
+		return in
+	}
+	s.inspectResponse(in, rec)
+	return in
+}
+
+func (s *Server) inspectResponse(in *inspection, rec *httptest.ResponseRecorder) {
+	in.OuterStatus = rec.Code
+	in.OuterHeaders = rec.Header()
+	in.OuterLinks = rec.Header()["Link"]
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), signedExchangeMIMEType) {
+		in.Error = "not a signed exchange: " + strings.TrimSpace(rec.Body.String())
+		return
+	}
+	e, err := signedexchange.ReadExchange(bytes.NewReader(rec.Body.Bytes()))
+	if err != nil {
+		in.Error = err.Error()
+		return
+	}
+	in.ContentURL = e.RequestURI
+	in.Status = e.ResponseStatus
+	in.Headers = e.ResponseHeaders
+	in.InnerLinks = e.ResponseHeaders["Link"]
+	in.Signature = e.SignatureHeaderValue
+	if in.HeaderIntegrity, err = headerIntegrity(e); err != nil {
+		in.Error = err.Error()
+	}
+
+	var verifyLog bytes.Buffer
+	payload, ok := e.Verify(s.now(), s.fetchCertForVerification, log.New(&verifyLog, "", 0))
+	in.Verified = ok
+	in.VerifyLog = verifyLog.String()
+	in.PayloadSize = len(payload)
+
+	if problems := lintLinks(in.OuterLinks, in.InnerLinks); problems != nil {
+		in.LinkProblems = problems
+	}
+}
+
+func (s *Server) inspectHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	name := strings.TrimPrefix(r.URL.Path, inspectPathPrefix)
+	if name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	format := q.Get("format")
+	q.Del("format")
+	u := &url.URL{Scheme: "https", Host: r.Host, Path: "/sxg/" + name, RawQuery: q.Encode()}
+	in := s.inspect(u)
+
+	if format == "json" {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(in)
+		return
+	}
+	if err := templates.ExecuteTemplate(w, "inspect.html", in); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
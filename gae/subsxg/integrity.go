@@ -0,0 +1,140 @@
+package subsxg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+// The header-integrity of an exchange is calculated at integrityURLPath,
+// for the exchange posted as the body or fetched from the "url" query
+// parameter.
+const integrityURLPath = "/integrity"
+
+// The limit of the exchanges which the header-integrity is calculated for.
+const maxIntegrityExchangeSize = 8 << 20
+
+// IntegrityInfo is the header-integrity of a signed exchange, and the
+// allowed-alt-sxg link to put in the inner headers of the parent exchange.
+type IntegrityInfo struct {
+	ContentURL      string `json:"content_url"`
+	HeaderIntegrity string `json:"header_integrity"`
+	Link            string `json:"link"`
+}
+
+// ReadHeaderIntegrity reads a signed exchange from r, and returns the
+// header-integrity of its signed headers. The Variants-04 and Variant-Key-04
+// headers of the exchange, if any, are copied to the link.
+func ReadHeaderIntegrity(r io.Reader) (*IntegrityInfo, error) {
+	e, err := signedexchange.ReadExchange(r)
+	if err != nil {
+		return nil, err
+	}
+	integrity, err := headerIntegrity(e)
+	if err != nil {
+		return nil, err
+	}
+	var params []linkParam
+	for _, name := range []string{variantsParam, variantKeyParam} {
+		if v := e.ResponseHeaders.Get(name); v != "" {
+			params = append(params, linkParam{name: name, value: v})
+		}
+	}
+	return &IntegrityInfo{
+		ContentURL:      e.RequestURI,
+		HeaderIntegrity: integrity,
+		Link:            allowedAltSXGLink(e.RequestURI, integrity, params...).String(),
+	}, nil
+}
+
+// FetchHeaderIntegrity fetches the signed exchange at rawURL with client,
+// and returns its header-integrity.
+func FetchHeaderIntegrity(client *http.Client, rawURL string) (*IntegrityInfo, error) {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", sxgContentType(version.Version1b3))
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %d", rawURL, res.StatusCode)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, signedExchangeMIMEType) {
+		return nil, fmt.Errorf("%s is not a signed exchange: %q", rawURL, ct)
+	}
+	return readLimitedHeaderIntegrity(res.Body)
+}
+
+func readLimitedHeaderIntegrity(r io.Reader) (*IntegrityInfo, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, maxIntegrityExchangeSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxIntegrityExchangeSize {
+		return nil, fmt.Errorf("the exchange is larger than %d bytes", maxIntegrityExchangeSize)
+	}
+	return ReadHeaderIntegrity(bytes.NewReader(body))
+}
+
+// integrityHandler calculates the header-integrity of the exchange posted
+// as the body, or of the exchange at the "url" query parameter, which must be
+// served by s itself.
+func (s *Server) integrityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	var info *IntegrityInfo
+	var err error
+	switch {
+	case r.Method == http.MethodPost:
+		info, err = readLimitedHeaderIntegrity(r.Body)
+	case r.URL.Query().Get("url") != "":
+		info, err = s.fetchHeaderIntegrity(r.URL.Query().Get("url"), r)
+	default:
+		http.Error(w, "POST a signed exchange, or set the url query parameter", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	enc.Encode(info)
+}
+
+// fetchHeaderIntegrity fetches the exchange at rawURL in process. Only the
+// hosts which s serves are fetched, so that the public handler can't be used
+// to make requests to arbitrary servers.
+func (s *Server) fetchHeaderIntegrity(rawURL string, r *http.Request) (*IntegrityInfo, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" {
+		return nil, fmt.Errorf("%q is not an https URL", rawURL)
+	}
+	if !s.isServedHost(u.Host, r) {
+		return nil, fmt.Errorf("%s is not served here, use the integrity command to fetch it", rawURL)
+	}
+	rec, err := s.fetchFromPublisher(u, sxgContentType(version.Version1b3))
+	if err != nil {
+		return nil, err
+	}
+	if rec.Code != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %d", rawURL, rec.Code)
+	}
+	return ReadHeaderIntegrity(bytes.NewReader(rec.Body.Bytes()))
+}
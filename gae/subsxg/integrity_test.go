@@ -0,0 +1,55 @@
+package subsxg
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIntegrityHandler(t *testing.T) {
+	s := newTestServer(t)
+	sxgURL := "https://" + testHost + autoSXGPathPrefix + "nikko_320.jpg.sxg"
+	sxg := get(t, s, sxgURL, testAccept)
+	want := headerIntegrityOf(t, readExchange(t, sxg))
+
+	posted := httptest.NewRequest(http.MethodPost, "https://"+testHost+integrityURLPath, bytes.NewReader(sxg.Body.Bytes()))
+	for _, req := range []*http.Request{
+		posted,
+		httptest.NewRequest(http.MethodGet, "https://"+testHost+integrityURLPath+"?url="+sxgURL, nil),
+	} {
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+		}
+		var info IntegrityInfo
+		if err := json.Unmarshal(rec.Body.Bytes(), &info); err != nil {
+			t.Fatal(err)
+		}
+		if info.HeaderIntegrity != want {
+			t.Errorf("header-integrity %s, want %s", info.HeaderIntegrity, want)
+		}
+		links, err := parseLinks([]string{info.Link})
+		if err != nil || len(links) != 1 || links[0].target != "https://"+testDomainName+"/amptest/img/nikko_320.jpg" || links[0].get("header-integrity") != want {
+			t.Errorf("link %q", info.Link)
+		}
+	}
+}
+
+func TestIntegrityHandlerDoesNotFetchOtherHosts(t *testing.T) {
+	s := newTestServer(t)
+	for _, rawURL := range []string{
+		"https://example.com/hello.sxg",
+		"https://127.0.0.1/sxg/hello.sxg",
+		"http://" + testHost + "/sxg/hello.sxg",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "https://"+testHost+integrityURLPath+"?url="+rawURL, nil)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", rawURL, rec.Code)
+		}
+	}
+}
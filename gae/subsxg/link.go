@@ -0,0 +1,227 @@
+package subsxg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// A link is a link-value of the Link header (RFC 8288).
+type link struct {
+	target string
+	params []linkParam
+}
+
+type linkParam struct {
+	name  string
+	value string
+	// bare is a parameter without a value, such as crossorigin.
+	bare bool
+}
+
+func newLink(target string, params ...linkParam) *link {
+	return &link{target: target, params: params}
+}
+
+// param returns the value of the first parameter named name.
+func (l *link) param(name string) (string, bool) {
+	for _, p := range l.params {
+		if p.name == name {
+			return p.value, true
+		}
+	}
+	return "", false
+}
+
+func (l *link) get(name string) string {
+	v, _ := l.param(name)
+	return v
+}
+
+func (l *link) hasRel(rel string) bool {
+	for _, r := range strings.Fields(strings.ToLower(l.get("rel"))) {
+		if r == rel {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteLinkParam(v string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// String serializes l with the parameter values quoted.
+func (l *link) String() string {
+	var b strings.Builder
+	b.WriteString("<" + l.target + ">")
+	for _, p := range l.params {
+		b.WriteString(";" + p.name)
+		if !p.bare {
+			b.WriteString("=" + quoteLinkParam(p.value))
+		}
+	}
+	return b.String()
+}
+
+type linkParser struct {
+	s   string
+	pos int
+}
+
+func (p *linkParser) skipSpaces() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *linkParser) done() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *linkParser) peek() byte {
+	if p.done() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *linkParser) token() string {
+	start := p.pos
+	for !p.done() && isToken(string(p.s[p.pos])) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+func (p *linkParser) quotedString() (string, error) {
+	var b strings.Builder
+	p.pos++ // The opening DQUOTE.
+	for !p.done() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.done() {
+				return "", errors.New("unterminated quoted-string")
+			}
+			b.WriteByte(p.s[p.pos])
+			p.pos++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", errors.New("unterminated quoted-string")
+}
+
+func (p *linkParser) link() (*link, error) {
+	p.skipSpaces()
+	if p.peek() != '<' {
+		return nil, fmt.Errorf("expected '<' at %d", p.pos)
+	}
+	end := strings.IndexByte(p.s[p.pos:], '>')
+	if end < 0 {
+		return nil, errors.New("unterminated URI-Reference")
+	}
+	l := &link{target: p.s[p.pos+1 : p.pos+end]}
+	p.pos += end + 1
+
+	for {
+		p.skipSpaces()
+		if p.done() || p.peek() == ',' {
+			return l, nil
+		}
+		if p.peek() != ';' {
+			return nil, fmt.Errorf("expected ';' at %d", p.pos)
+		}
+		p.pos++
+		p.skipSpaces()
+		name := strings.ToLower(p.token())
+		if name == "" {
+			return nil, fmt.Errorf("empty parameter at %d", p.pos)
+		}
+		p.skipSpaces()
+		if p.peek() != '=' {
+			l.params = append(l.params, linkParam{name: name, bare: true})
+			continue
+		}
+		p.pos++
+		p.skipSpaces()
+		var value string
+		if p.peek() == '"' {
+			v, err := p.quotedString()
+			if err != nil {
+				return nil, err
+			}
+			value = v
+		} else {
+			value = p.token()
+			if value == "" {
+				return nil, fmt.Errorf("invalid value of %s at %d", name, p.pos)
+			}
+		}
+		l.params = append(l.params, linkParam{name: name, value: value})
+	}
+}
+
+// parseLinks parses the Link header values. The parameter names are
+// lower-cased.
+func parseLinks(values []string) ([]*link, error) {
+	var links []*link
+	for _, value := range values {
+		p := &linkParser{s: value}
+		for {
+			p.skipSpaces()
+			if p.done() {
+				break
+			}
+			l, err := p.link()
+			if err != nil {
+				return links, fmt.Errorf("%q: %v", value, err)
+			}
+			links = append(links, l)
+			p.skipSpaces()
+			if p.peek() == ',' {
+				p.pos++
+			}
+		}
+	}
+	return links, nil
+}
+
+// The links which the scenarios add.
+
+func alternateLink(sxgURL string, anchor string, ver string, params ...linkParam) *link {
+	l := newLink(sxgURL,
+		linkParam{name: "rel", value: "alternate"},
+		linkParam{name: "type", value: ver})
+	l.params = append(l.params, params...)
+	l.params = append(l.params, linkParam{name: "anchor", value: anchor})
+	return l
+}
+
+func allowedAltSXGLink(target string, headerIntegrity string, params ...linkParam) *link {
+	l := newLink(target, linkParam{name: "rel", value: "allowed-alt-sxg"})
+	l.params = append(l.params, params...)
+	l.params = append(l.params, linkParam{name: "header-integrity", value: headerIntegrity})
+	return l
+}
+
+// variantParams returns the parameters of the links to a variant of the
+// resources which vary by the request headers.
+func variantParams(variants string, variantKey string) []linkParam {
+	return []linkParam{
+		{name: variantsParam, value: variants},
+		{name: variantKeyParam, value: variantKey},
+	}
+}
+
+func preloadLink(target string, as string, params ...linkParam) *link {
+	l := newLink(target,
+		linkParam{name: "rel", value: "preload"},
+		linkParam{name: "as", value: as})
+	l.params = append(l.params, params...)
+	return l
+}
@@ -0,0 +1,113 @@
+package subsxg
+
+import (
+	"strings"
+)
+
+// The names of the variants parameters of the links.
+const (
+	variantsParam   = "variants-04"
+	variantKeyParam = "variant-key-04"
+)
+
+// preloads reports whether a preload link in links loads u, either as the
+// target or as a candidate of the imagesrcset.
+func preloads(links []*link, u string) bool {
+	for _, l := range links {
+		if !l.hasRel("preload") {
+			continue
+		}
+		if l.target == u {
+			return true
+		}
+		for _, candidate := range strings.Split(l.get("imagesrcset"), ",") {
+			if f := strings.Fields(candidate); len(f) > 0 && f[0] == u {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// lintVariants checks that the variant key of l is one of its variants.
+func lintVariants(l *link) []string {
+	variants, hasVariants := l.param(variantsParam)
+	key, hasKey := l.param(variantKeyParam)
+	switch {
+	case !hasVariants && !hasKey:
+		return nil
+	case !hasVariants:
+		return []string{l.target + ": " + variantKeyParam + " without " + variantsParam}
+	case !hasKey:
+		return []string{l.target + ": " + variantsParam + " without " + variantKeyParam}
+	}
+	values := strings.Split(variants, ";")
+	for _, v := range values[1:] {
+		if strings.TrimSpace(v) == key {
+			return nil
+		}
+	}
+	return []string{l.target + ": " + variantKeyParam + " " + key + " is not in " + variantsParam + " " + variants}
+}
+
+// lintLinks checks the outer Link headers of an exchange against its inner
+// Link headers: each alternate exchange needs the allowed-alt-sxg link with
+// the same variants and a preload link, and the values must be quoted
+// correctly. It returns the problems.
+func lintLinks(outer []string, inner []string) []string {
+	var problems []string
+	outerLinks, err := parseLinks(outer)
+	if err != nil {
+		problems = append(problems, "outer Link: "+err.Error())
+	}
+	innerLinks, err := parseLinks(inner)
+	if err != nil {
+		problems = append(problems, "inner Link: "+err.Error())
+	}
+
+	type variantKey struct{ url, key string }
+	allowed := map[variantKey]*link{}
+	for _, l := range innerLinks {
+		if !l.hasRel("allowed-alt-sxg") {
+			continue
+		}
+		problems = append(problems, lintVariants(l)...)
+		integrity, ok := l.param("header-integrity")
+		if !ok {
+			problems = append(problems, l.target+": allowed-alt-sxg without header-integrity")
+		} else if !strings.HasPrefix(integrity, "sha256-") {
+			problems = append(problems, l.target+": header-integrity "+integrity+" is not sha256")
+		}
+		allowed[variantKey{l.target, l.get(variantKeyParam)}] = l
+	}
+
+	alternates := map[variantKey]bool{}
+	for _, l := range outerLinks {
+		if !l.hasRel("alternate") || !strings.HasPrefix(l.get("type"), signedExchangeMIMEType) {
+			continue
+		}
+		problems = append(problems, lintVariants(l)...)
+		anchor, ok := l.param("anchor")
+		if !ok {
+			problems = append(problems, l.target+": alternate without anchor")
+			continue
+		}
+		key := variantKey{anchor, l.get(variantKeyParam)}
+		alternates[key] = true
+		a, ok := allowed[key]
+		if !ok {
+			problems = append(problems, anchor+": alternate "+l.target+" without allowed-alt-sxg")
+		} else if a.get(variantsParam) != l.get(variantsParam) {
+			problems = append(problems, anchor+": variants of alternate and allowed-alt-sxg differ")
+		}
+		if !preloads(innerLinks, anchor) {
+			problems = append(problems, anchor+": alternate "+l.target+" without preload")
+		}
+	}
+	for _, l := range innerLinks {
+		if l.hasRel("allowed-alt-sxg") && !alternates[variantKey{l.target, l.get(variantKeyParam)}] {
+			problems = append(problems, l.target+": allowed-alt-sxg without alternate")
+		}
+	}
+	return problems
+}
@@ -0,0 +1,112 @@
+package subsxg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	links, err := parseLinks([]string{
+		`<https://a.test/x.sxg>;rel="alternate";type="application/signed-exchange;v=b3";anchor="https://a.test/x.js"`,
+		`<https://a.test/x.js>; rel=preload; as=script, </y.woff2>;rel="preload";as="font";crossorigin`,
+		`<https://a.test/z.jpg>;rel="allowed-alt-sxg";header-integrity="sha256-a\"b\\c"`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*link{
+		newLink("https://a.test/x.sxg",
+			linkParam{name: "rel", value: "alternate"},
+			linkParam{name: "type", value: "application/signed-exchange;v=b3"},
+			linkParam{name: "anchor", value: "https://a.test/x.js"}),
+		newLink("https://a.test/x.js",
+			linkParam{name: "rel", value: "preload"},
+			linkParam{name: "as", value: "script"}),
+		newLink("/y.woff2",
+			linkParam{name: "rel", value: "preload"},
+			linkParam{name: "as", value: "font"},
+			linkParam{name: "crossorigin", bare: true}),
+		newLink("https://a.test/z.jpg",
+			linkParam{name: "rel", value: "allowed-alt-sxg"},
+			linkParam{name: "header-integrity", value: `sha256-a"b\c`}),
+	}
+	if !reflect.DeepEqual(links, want) {
+		t.Errorf("parseLinks() = %v, want %v", links, want)
+	}
+
+	for _, l := range want {
+		parsed, err := parseLinks([]string{l.String()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(parsed) != 1 || !reflect.DeepEqual(parsed[0], l) {
+			t.Errorf("%s doesn't round-trip: %v", l, parsed)
+		}
+	}
+}
+
+func TestParseLinksErrors(t *testing.T) {
+	for _, value := range []string{
+		`<https://a.test/x.sxg>;rel="alternate";anchor="https://a.test/x.js";`,
+		`<https://a.test/x.sxg>;rel="alternate`,
+		`<https://a.test/x.sxg;rel=alternate`,
+		`https://a.test/x.sxg;rel=alternate`,
+		`<https://a.test/x.sxg>;rel=`,
+	} {
+		if _, err := parseLinks([]string{value}); err == nil {
+			t.Errorf("parseLinks(%q) succeeded", value)
+		}
+	}
+}
+
+func TestLintLinks(t *testing.T) {
+	alternate := alternateLink("https://sxg.test/sxg/a.sxg", "https://a.test/a.jpg", "application/signed-exchange;v=b3",
+		linkParam{name: variantsParam, value: "accept;image/jpeg;image/webp"},
+		linkParam{name: variantKeyParam, value: "image/jpeg"})
+	allowed := allowedAltSXGLink("https://a.test/a.jpg", "sha256-x",
+		linkParam{name: variantsParam, value: "accept;image/jpeg;image/webp"},
+		linkParam{name: variantKeyParam, value: "image/jpeg"})
+	preload := preloadLink("https://a.test/a.jpg", "image")
+
+	tests := []struct {
+		name  string
+		outer []string
+		inner []string
+		want  []string
+	}{
+		{"valid", []string{alternate.String()}, []string{allowed.String(), preload.String()}, nil},
+		{"trailing semicolon", []string{alternate.String() + ";"}, []string{allowed.String(), preload.String()}, []string{"outer Link", "without alternate"}},
+		{"no allowed-alt-sxg", []string{alternate.String()}, []string{preload.String()}, []string{"without allowed-alt-sxg"}},
+		{"no preload", []string{alternate.String()}, []string{allowed.String()}, []string{"without preload"}},
+		{"no alternate", nil, []string{allowed.String(), preload.String()}, []string{"without alternate"}},
+		{
+			"unknown variant key",
+			[]string{alternateLink("https://sxg.test/sxg/a.sxg", "https://a.test/a.jpg", "application/signed-exchange;v=b3",
+				linkParam{name: variantsParam, value: "accept;image/jpeg"},
+				linkParam{name: variantKeyParam, value: "image/jpeg"}).String()},
+			[]string{allowed.String(), preload.String()},
+			[]string{"variants of alternate and allowed-alt-sxg differ"},
+		},
+		{
+			"variant key not in variants",
+			[]string{alternate.String()},
+			[]string{allowedAltSXGLink("https://a.test/a.jpg", "sha256-x",
+				linkParam{name: variantsParam, value: "accept;image/webp"},
+				linkParam{name: variantKeyParam, value: "image/jpeg"}).String(), preload.String()},
+			[]string{"is not in variants-04", "differ"},
+		},
+	}
+	for _, test := range tests {
+		problems := lintLinks(test.outer, test.inner)
+		if len(problems) != len(test.want) {
+			t.Errorf("%s: lintLinks() = %q, want %d problems", test.name, problems, len(test.want))
+			continue
+		}
+		for i, want := range test.want {
+			if !strings.Contains(problems[i], want) {
+				t.Errorf("%s: problem %q doesn't contain %q", test.name, problems[i], want)
+			}
+		}
+	}
+}
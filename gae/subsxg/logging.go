@@ -0,0 +1,61 @@
+package subsxg
+
+import (
+	"encoding/json"
+	"path"
+	"time"
+)
+
+// signingLog is written for each request for a signed exchange, as a JSON
+// line which Cloud Logging parses as a structured log.
+type signingLog struct {
+	Severity      string  `json:"severity"`
+	Message       string  `json:"message"`
+	Scenario      string  `json:"scenario"`
+	Identity      string  `json:"identity"`
+	Version       string  `json:"version"`
+	PayloadSize   int     `json:"payload_size"`
+	MIRecordSize  int     `json:"mi_record_size"`
+	SigningTimeMs float64 `json:"signing_time_ms"`
+	Outcome       string  `json:"outcome"`
+	Error         string  `json:"error,omitempty"`
+	// HeaderRules are the inner response headers which broke the signing
+	// rules, with the rules they triggered.
+	HeaderRules []string `json:"header_rules,omitempty"`
+}
+
+// logSigning records the outcome of serving params for requestPath, both in
+// the structured logs and in the metrics.
+func (s *Server) logSigning(params *exchangeParams, requestPath string, outcome string, signingTime time.Duration, err error) {
+	entry := signingLog{
+		Severity:      "INFO",
+		Message:       "sxg " + outcome,
+		Scenario:      path.Base(requestPath),
+		Identity:      params.identity.name,
+		Version:       string(params.ver),
+		PayloadSize:   len(params.payload),
+		MIRecordSize:  params.recordSize,
+		SigningTimeMs: float64(signingTime) / float64(time.Millisecond),
+		Outcome:       outcome,
+	}
+	for _, v := range params.headerViolations {
+		entry.HeaderRules = append(entry.HeaderRules, v.String())
+	}
+	if err != nil {
+		entry.Severity = "ERROR"
+		entry.Error = err.Error()
+	}
+
+	s.metrics.sxgRequests.inc(params.identity.name, outcome)
+	if outcome == "signed" {
+		s.metrics.signingDuration.observe(signingTime.Seconds(), params.identity.name)
+	}
+
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	s.logMu.Lock()
+	defer s.logMu.Unlock()
+	s.logOutput.Write(append(b, '\n'))
+}
@@ -0,0 +1,191 @@
+package subsxg
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The metrics are exposed at metricsURLPath in the Prometheus text format.
+const metricsURLPath = "/metrics"
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newCounterVec(name string, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (c *counterVec) inc(labelValues ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[formatLabels(c.labels, labelValues)]++
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, labels := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s%s %g\n", c.name, labels, c.values[labels])
+	}
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogramSeries{}}
+}
+
+func (h *histogramVec) observe(value float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labelValues, "\x00")
+	s, ok := h.series[key]
+	if !ok {
+		s = &histogramSeries{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.sum += value
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	var keys []string
+	for key := range h.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	bucketLabels := append(append([]string{}, h.labels...), "le")
+	for _, key := range keys {
+		s := h.series[key]
+		labelValues := strings.Split(key, "\x00")
+		bucketValues := append(append([]string{}, labelValues...), "")
+		for i, upper := range h.buckets {
+			bucketValues[len(labelValues)] = fmt.Sprintf("%g", upper)
+			labels := formatLabels(bucketLabels, bucketValues)
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.counts[i])
+		}
+		bucketValues[len(labelValues)] = "+Inf"
+		labels := formatLabels(bucketLabels, bucketValues)
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, labels, s.count)
+		labels = formatLabels(h.labels, labelValues)
+		fmt.Fprintf(w, "%s_sum%s %g\n", h.name, labels, s.sum)
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, labels, s.count)
+	}
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var pairs []string
+	for i, name := range names {
+		value := ""
+		if i < len(values) {
+			value = values[i]
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%q", name, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func sortedKeys(m map[string]float64) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ocspRefreshCounter counts the OCSP refreshes of the identities, which are
+// shared by the servers.
+type ocspRefreshCounter struct {
+	ids []*Identity
+}
+
+func (c *ocspRefreshCounter) write(w io.Writer) {
+	name := "sxg_ocsp_refreshes_total"
+	fmt.Fprintf(w, "# HELP %s OCSP refreshes by outcome.\n# TYPE %s counter\n", name, name)
+	for _, id := range c.ids {
+		id.mu.Lock()
+		ok, errors := id.ocspOK, id.ocspErrors
+		id.mu.Unlock()
+		fmt.Fprintf(w, "%s%s %d\n", name, formatLabels([]string{"identity", "outcome"}, []string{id.name, "error"}), errors)
+		fmt.Fprintf(w, "%s%s %d\n", name, formatLabels([]string{"identity", "outcome"}, []string{id.name, "ok"}), ok)
+	}
+}
+
+type serverMetrics struct {
+	sxgRequests     *counterVec
+	signingDuration *histogramVec
+	cacheLookups    *counterVec
+	certFetches     *counterVec
+
+	all []metric
+}
+
+func newServerMetrics(ids ...*Identity) *serverMetrics {
+	m := &serverMetrics{
+		sxgRequests: newCounterVec(
+			"sxg_requests_total", "Requests for the signed exchanges by outcome.",
+			"identity", "outcome"),
+		signingDuration: newHistogramVec(
+			"sxg_signing_duration_seconds", "Time spent to sign the exchanges.",
+			[]float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+			"identity"),
+		cacheLookups: newCounterVec(
+			"sxg_cache_lookups_total", "Lookups of the cache emulator by result.",
+			"result"),
+		certFetches: newCounterVec(
+			"sxg_cert_fetches_total", "Requests for the cert-chains by source, which is internal for the in-process fetches.",
+			"identity", "source"),
+	}
+	m.all = []metric{m.sxgRequests, m.signingDuration, m.cacheLookups, &ocspRefreshCounter{ids: ids}, m.certFetches}
+	return m
+}
+
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, m := range s.metrics.all {
+		m.write(w)
+	}
+}
@@ -0,0 +1,186 @@
+package subsxg
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+// The largest payload which the signing middleware signs by default. Larger
+// responses are passed through.
+const defaultMaxSignedPayloadSize = 8 << 20
+
+type signingMiddleware struct {
+	next           http.Handler
+	identity       *Identity
+	certURL        string
+	maxPayloadSize int
+	now            func() time.Time
+}
+
+// A SigningOption configures the signing middleware.
+type SigningOption func(m *signingMiddleware)
+
+// WithMaxPayloadSize sets the largest payload which is signed.
+func WithMaxPayloadSize(n int) SigningOption {
+	return func(m *signingMiddleware) { m.maxPayloadSize = n }
+}
+
+// WithSigningClock sets the clock which the signatures are dated with.
+func WithSigningClock(now func() time.Time) SigningOption {
+	return func(m *signingMiddleware) { m.now = now }
+}
+
+// NewSigningMiddleware returns a middleware which signs the responses of the
+// wrapped handler with id for the clients which accept signed exchanges. The
+// content URLs are the request URLs on the domain of id, and certURL is the
+// absolute URL at which the cert-chain of id is served. The responses which
+// can't be signed, and the responses for the other clients, are passed
+// through unchanged.
+func NewSigningMiddleware(id *Identity, certURL string, opts ...SigningOption) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		m := &signingMiddleware{
+			next:           next,
+			identity:       id,
+			certURL:        certURL,
+			maxPayloadSize: defaultMaxSignedPayloadSize,
+			now:            time.Now,
+		}
+		for _, opt := range opts {
+			opt(m)
+		}
+		return m
+	}
+}
+
+// signingRecorder buffers the response of the wrapped handler to sign it.
+// Once the body grows larger than max bytes, it can't be signed, so the
+// response is passed through to w from then on instead of buffered.
+type signingRecorder struct {
+	w   http.ResponseWriter
+	max int
+
+	header http.Header
+	// code and snapshot are the status and the headers at the time the
+	// header was written.
+	code     int
+	snapshot http.Header
+	body     bytes.Buffer
+	passing  bool
+}
+
+func newSigningRecorder(w http.ResponseWriter, max int) *signingRecorder {
+	return &signingRecorder{w: w, max: max, header: http.Header{}}
+}
+
+func (r *signingRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *signingRecorder) WriteHeader(code int) {
+	if r.code != 0 {
+		return
+	}
+	r.code = code
+	r.snapshot = r.header.Clone()
+}
+
+func (r *signingRecorder) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	if !r.passing && r.body.Len()+len(b) > r.max {
+		r.passThrough()
+	}
+	if r.passing {
+		return r.w.Write(b)
+	}
+	return r.body.Write(b)
+}
+
+// passThrough writes the buffered response to w, and makes the later writes
+// go to w.
+func (r *signingRecorder) passThrough() {
+	r.WriteHeader(http.StatusOK)
+	if r.passing {
+		return
+	}
+	r.passing = true
+	for name, values := range r.snapshot {
+		r.w.Header()[name] = append(r.w.Header()[name], values...)
+	}
+	r.w.WriteHeader(r.code)
+	r.w.Write(r.body.Bytes())
+	r.body.Reset()
+}
+
+// checkSignable reports why the buffered response can't be signed, or nil.
+func (m *signingMiddleware) checkSignable(rec *signingRecorder) error {
+	if rec.passing {
+		return fmt.Errorf("payload is larger than %d bytes", m.maxPayloadSize)
+	}
+	if rec.code != http.StatusOK {
+		return fmt.Errorf("status is %d", rec.code)
+	}
+	if _, violations := sanitizeHeaders(rec.snapshot); len(violations) > 0 {
+		return &headerRuleError{violations: violations}
+	}
+	return nil
+}
+
+func (m *signingMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ver := version.Version1b3
+	if r.Method != http.MethodGet {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Add("Vary", "Accept")
+	if !acceptsSignedExchange(r.Header, ver) {
+		m.next.ServeHTTP(w, r)
+		return
+	}
+
+	rec := newSigningRecorder(w, m.maxPayloadSize)
+	m.next.ServeHTTP(rec, r)
+	// As net/http does, a handler which writes nothing responds with 200.
+	rec.WriteHeader(http.StatusOK)
+	if err := m.checkSignable(rec); err != nil {
+		rec.passThrough()
+		return
+	}
+
+	resHeader := rec.snapshot.Clone()
+	resHeader.Del("Content-Type")
+	resHeader.Del("Content-Length")
+	contentType := rec.snapshot.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(rec.body.Bytes())
+	}
+	params := &exchangeParams{
+		ver:         ver,
+		contentUrl:  "https://" + m.identity.domainName + r.URL.RequestURI(),
+		certUrl:     m.certURL,
+		validityUrl: "https://" + m.identity.domainName + "/cert/null.validity.msg",
+		contentType: contentType,
+		status:      http.StatusOK,
+		resHeader:   resHeader,
+		payload:     rec.body.Bytes(),
+		recordSize:  defaultMIRecordSize,
+		date:        m.now().Add(-time.Second * 10),
+		identity:    m.identity,
+	}
+	e, err := createExchange(params)
+	var body bytes.Buffer
+	if err == nil {
+		err = e.Write(&body)
+	}
+	if err != nil {
+		m.identity.recordSigningError(err, m.now())
+		http.Error(w, "failed to sign: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", sxgContentType(ver))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,126 @@
+package subsxg
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveSigned(t *testing.T, s *Server, h http.HandlerFunc, method string, accept string, opts ...SigningOption) *httptest.ResponseRecorder {
+	t.Helper()
+	handler := NewSigningMiddleware(s.defaultIdentity, "https://"+testHost+s.defaultIdentity.certURLPath, opts...)(h)
+	req := httptest.NewRequest(method, "https://"+testDomainName+"/page.html?q=1", nil)
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestSigningMiddleware(t *testing.T) {
+	s := newTestServer(t)
+	rec := serveSigned(t, s, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(defaultPayload))
+		// Changes after the write are not sent, so they are not signed.
+		w.Header().Set("X-Late", "1")
+	}, http.MethodGet, testAccept)
+	if vary := rec.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("Vary is %q", vary)
+	}
+	e := readExchange(t, rec)
+	if want := "https://" + testDomainName + "/page.html?q=1"; e.RequestURI != want {
+		t.Errorf("content URL is %s, want %s", e.RequestURI, want)
+	}
+	if got := e.ResponseHeaders.Get("Cache-Control"); got != "max-age=60" {
+		t.Errorf("Cache-Control is %q", got)
+	}
+	if got := e.ResponseHeaders.Get("X-Late"); got != "" {
+		t.Errorf("the header set after the write is signed: %q", got)
+	}
+	var logBuf bytes.Buffer
+	payload, ok := e.Verify(time.Now(), s.fetchCertForVerification, log.New(&logBuf, "", 0))
+	if !ok {
+		t.Fatalf("not verified: %s", logBuf.String())
+	}
+	if string(payload) != defaultPayload {
+		t.Errorf("payload is %q", payload)
+	}
+}
+
+func TestSigningMiddlewarePassesThrough(t *testing.T) {
+	s := newTestServer(t)
+	body := strings.Repeat("a", 100)
+	for _, test := range []struct {
+		name   string
+		method string
+		accept string
+		status int
+		header http.Header
+		opts   []SigningOption
+	}{
+		{name: "not found", method: http.MethodGet, accept: testAccept, status: http.StatusNotFound},
+		{name: "redirect", method: http.MethodGet, accept: testAccept, status: http.StatusFound, header: http.Header{"Location": {"/"}}},
+		{name: "set-cookie", method: http.MethodGet, accept: testAccept, status: http.StatusOK, header: http.Header{"Set-Cookie": {"a=b"}}},
+		{name: "oversized", method: http.MethodGet, accept: testAccept, status: http.StatusOK, opts: []SigningOption{WithMaxPayloadSize(10)}},
+		{name: "no sxg in accept", method: http.MethodGet, accept: "text/html,*/*;q=0.8", status: http.StatusOK},
+		{name: "no accept", method: http.MethodGet, status: http.StatusOK},
+		{name: "post", method: http.MethodPost, accept: testAccept, status: http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rec := serveSigned(t, s, func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range test.header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(test.status)
+				// Written in pieces, so that the oversized body is passed
+				// through partway.
+				for i := 0; i < len(body); i += 7 {
+					end := i + 7
+					if end > len(body) {
+						end = len(body)
+					}
+					w.Write([]byte(body[i:end]))
+				}
+			}, test.method, test.accept, test.opts...)
+			if rec.Code != test.status {
+				t.Errorf("status %d, want %d", rec.Code, test.status)
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "text/plain" {
+				t.Errorf("Content-Type is %q", ct)
+			}
+			if rec.Body.String() != body {
+				t.Errorf("body is %q", rec.Body.String())
+			}
+			for name, values := range test.header {
+				if got := rec.Header()[name]; len(got) != len(values) || got[0] != values[0] {
+					t.Errorf("%s is %q, want %q", name, got, values)
+				}
+			}
+		})
+	}
+}
+
+// The body is not buffered past the largest payload which can be signed.
+func TestSigningMiddlewareStopsBuffering(t *testing.T) {
+	s := newTestServer(t)
+	var buffered int
+	rec := serveSigned(t, s, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("0123456789"))
+		w.Write([]byte("0123456789"))
+		buffered = w.(*signingRecorder).body.Len()
+	}, http.MethodGet, testAccept, WithMaxPayloadSize(15))
+	if buffered != 0 {
+		t.Errorf("%d bytes are buffered after the body passed the limit", buffered)
+	}
+	if rec.Code != http.StatusOK || rec.Body.String() != "01234567890123456789" {
+		t.Errorf("status %d, body %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,115 @@
+package subsxg
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// The paths of the content URLs in the scenarios, and the contents which are
+// signed for them.
+var (
+	demoOriginPaths = map[string]string{
+		"/hello.html":                "hello.html",
+		"/amptest/amptestnocdn.html": "amptestnocdn.html",
+		"/amptest/fonttest.html":     "fonttest.html",
+		"/amptest/corb_test.html":    "corbtest.html",
+		"/amptest/js/v0.js":          "v0.js",
+		"/amptest/img/nikko_320.jpg": "nikko_320.jpg",
+		"/amptest/img/nikko_640.jpg": "nikko_640.jpg",
+		"/amptest/css/a.css":         "a.css",
+		"/amptest/css/b.css":         "b.css",
+	}
+	altOriginPaths = map[string]string{
+		"/hello.html":                "hello.html",
+		"/fonts/wapuro-mincho.woff2": "wapuro-mincho.woff2",
+	}
+
+	// builtinContents are the payloads which are not in the content store.
+	builtinContents = NewMemContentStore(map[string][]byte{
+		"hello.html": []byte(defaultPayload),
+		"a.css":      []byte(""),
+		"b.css":      []byte(""),
+	})
+)
+
+// contentKey returns the key in the content store of the content served at
+// path on the origin of domainName. Besides the content URLs of the
+// scenarios, the contents in the content store are served at their keys.
+func (s *Server) contentKey(domainName string, path string) (string, bool) {
+	var paths map[string]string
+	switch domainName {
+	case s.demoDomainName:
+		paths = demoOriginPaths
+	case s.altDemoDomainName:
+		paths = altOriginPaths
+	default:
+		return "", false
+	}
+	if key, ok := paths[path]; ok {
+		return key, true
+	}
+	return strings.TrimPrefix(path, "/"), true
+}
+
+// originPath returns the path on the origin of domainName which serves the
+// content of key. The content URLs of the scenarios are preferred, so that the
+// relative URLs in the contents resolve as they do on the demo origin.
+func (s *Server) originPath(domainName string, key string) string {
+	paths := demoOriginPaths
+	if domainName == s.altDemoDomainName {
+		paths = altOriginPaths
+	}
+	for p, k := range paths {
+		if k == key {
+			return p
+		}
+	}
+	return "/" + key
+}
+
+// originContent returns the content served at path on the origin of
+// domainName.
+func (s *Server) originContent(domainName string, path string) (*Content, bool) {
+	key, ok := s.contentKey(domainName, path)
+	if !ok {
+		return nil, false
+	}
+	if c, ok := builtinContents.Get(key); ok {
+		return c, true
+	}
+	return s.contents.Get(key)
+}
+
+// OriginHandler serves the unsigned contents when the request is for the
+// domains of the identities, so that the fallback URLs of the exchanges work.
+// Other requests are handled by next.
+func (s *Server) OriginHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		c, ok := s.originContent(host, r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// The variants scenario signs the WebP images for the JPEG URLs.
+		if strings.HasSuffix(c.path, ".jpg") {
+			w.Header().Add("Vary", "Accept")
+			if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+				if webp, ok := s.contents.Get(strings.TrimSuffix(c.path, ".jpg") + ".webp"); ok {
+					c = webp
+				}
+			}
+		}
+		if host == s.altDemoDomainName {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		w.Header().Set("Content-Type", c.contentType)
+		w.Header().Set("Cache-Control", "public, max-age=600")
+		w.Write(c.payload)
+	})
+}
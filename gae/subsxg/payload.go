@@ -0,0 +1,183 @@
+package subsxg
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The query parameters which replace the payload of any scenario with a
+// synthetic one. The parameters with the "sub_" prefix replace the payloads
+// of the alternate exchanges of the subresources.
+const (
+	payloadParam    = "payload"
+	sizeParam       = "size"
+	recordsParam    = "records"
+	offsetParam     = "offset"
+	recordSizeParam = "record_size"
+	limitParam      = "limit"
+	seedParam       = "seed"
+)
+
+var payloadParams = []string{payloadParam, sizeParam, recordsParam, offsetParam, recordSizeParam, limitParam, seedParam}
+
+// The kinds of the synthetic payloads, the values of the payload parameter.
+const (
+	// size bytes of pseudo-random bytes, which don't compress.
+	payloadRandom = "random"
+	// size bytes of a repeated line of text.
+	payloadCompressible = "compressible"
+	// A zero-length body.
+	payloadEmpty = "empty"
+	// records MI records of record_size bytes, plus offset bytes, which can
+	// be negative.
+	payloadBoundary = "boundary"
+	// limit+1 random bytes.
+	payloadOversize = "oversize"
+)
+
+// The limits of the synthetic payloads. The default limit of the oversize
+// payloads is the largest exchange which the cache emulator accepts. The MI
+// records are bounded in size and in number, since each record costs a proof
+// and every parent re-encodes its alternate exchanges for the
+// header-integrity.
+const (
+	defaultSyntheticPayloadSize = 1 << 20
+	maxSyntheticPayloadSize     = 16 << 20
+	defaultOversizeLimit        = maxCachedExchangeSize
+	minRecordSize               = 16
+	maxPayloadRecords           = 1 << 16
+)
+
+const compressibleLine = "sub-sxg compressible payload 0123456789\n"
+
+// parseSize parses a number of bytes with an optional k or m suffix, which
+// multiplies it by 1024 or 1024*1024.
+func parseSize(s string) (int, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	unit := 1
+	for _, u := range []struct {
+		suffix string
+		unit   int
+	}{{"kb", 1 << 10}, {"k", 1 << 10}, {"mb", 1 << 20}, {"m", 1 << 20}} {
+		if strings.HasSuffix(lower, u.suffix) {
+			lower = strings.TrimSuffix(lower, u.suffix)
+			unit = u.unit
+			break
+		}
+	}
+	n, err := strconv.Atoi(lower)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if n > maxSyntheticPayloadSize/unit {
+		return 0, fmt.Errorf("the size %q is larger than %d bytes", s, maxSyntheticPayloadSize)
+	}
+	return n * unit, nil
+}
+
+func sizeFromQuery(q url.Values, name string, def int) (int, error) {
+	if v := q.Get(name); v != "" {
+		return parseSize(v)
+	}
+	return def, nil
+}
+
+// randomPayload returns size pseudo-random bytes of seed. The same query
+// always gives the same bytes, so that the header-integrity derived for the
+// parent matches the exchange served later.
+func randomPayload(size int, seed int64) []byte {
+	payload := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(payload)
+	return payload
+}
+
+// applySyntheticPayload replaces the payload and the MI record size of params
+// by the query parameters. params is not modified if the query
+// has neither the payload nor the record_size parameter.
+func applySyntheticPayload(params *exchangeParams, q url.Values) error {
+	if v := q.Get(recordSizeParam); v != "" {
+		recordSize, err := parseSize(v)
+		if err != nil || recordSize < minRecordSize {
+			return fmt.Errorf("invalid %s %q, which must be at least %d bytes", recordSizeParam, v, minRecordSize)
+		}
+		params.recordSize = recordSize
+	}
+	if err := setSyntheticPayload(params, q); err != nil {
+		return err
+	}
+	if records := len(params.payload) / params.recordSize; records > maxPayloadRecords {
+		return fmt.Errorf("%d records of %d bytes are more than %d", records, params.recordSize, maxPayloadRecords)
+	}
+	return nil
+}
+
+// setSyntheticPayload replaces the payload of params by the payload
+// parameter.
+func setSyntheticPayload(params *exchangeParams, q url.Values) error {
+	kind := q.Get(payloadParam)
+	if kind == "" {
+		return nil
+	}
+	var seed int64
+	if v := q.Get(seedParam); v != "" {
+		var err error
+		if seed, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return fmt.Errorf("invalid %s %q", seedParam, v)
+		}
+	}
+
+	switch kind {
+	case payloadRandom, payloadCompressible:
+		size, err := sizeFromQuery(q, sizeParam, defaultSyntheticPayloadSize)
+		if err != nil {
+			return err
+		}
+		if kind == payloadRandom {
+			params.payload = randomPayload(size, seed)
+			return nil
+		}
+		lines := bytes.Repeat([]byte(compressibleLine), size/len(compressibleLine)+1)
+		params.payload = lines[:size]
+	case payloadEmpty:
+		params.payload = []byte{}
+	case payloadBoundary:
+		records, err := strconv.Atoi(q.Get(recordsParam))
+		if err != nil || records < 0 {
+			records = 1
+		}
+		offset, _ := strconv.Atoi(q.Get(offsetParam))
+		size := records*params.recordSize + offset
+		if records > maxSyntheticPayloadSize/params.recordSize || size < 0 || size > maxSyntheticPayloadSize {
+			return fmt.Errorf("%d records of %d bytes and %d bytes is out of range", records, params.recordSize, offset)
+		}
+		params.payload = randomPayload(size, seed)
+	case payloadOversize:
+		limit, err := sizeFromQuery(q, limitParam, defaultOversizeLimit)
+		if err != nil {
+			return err
+		}
+		if limit >= maxSyntheticPayloadSize {
+			return fmt.Errorf("the limit %d is not smaller than %d bytes", limit, maxSyntheticPayloadSize)
+		}
+		params.payload = randomPayload(limit+1, seed)
+	default:
+		return fmt.Errorf("unknown %s %q", payloadParam, kind)
+	}
+	return nil
+}
+
+// subPayloadQuery returns the query parameters which give the alternate
+// exchanges the synthetic payloads of the "sub_" parameters in q.
+func subPayloadQuery(q url.Values) string {
+	sub := url.Values{}
+	for _, name := range payloadParams {
+		if v := q.Get(subShapingPrefix + name); v != "" {
+			sub.Set(name, v)
+		}
+	}
+	return sub.Encode()
+}
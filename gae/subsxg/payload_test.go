@@ -0,0 +1,128 @@
+package subsxg
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSize(t *testing.T) {
+	for s, want := range map[string]int{"0": 0, "12": 12, "3k": 3 << 10, "3KB": 3 << 10, "2m": 2 << 20, "16m": 16 << 20} {
+		if got, err := parseSize(s); err != nil || got != want {
+			t.Errorf("parseSize(%q) = %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "-1", "k", "1g", "17m", "1.5m"} {
+		if _, err := parseSize(s); err == nil {
+			t.Errorf("parseSize(%q) succeeded", s)
+		}
+	}
+}
+
+func TestSyntheticPayloads(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...))
+	verify := func(t *testing.T, rawURL string) []byte {
+		t.Helper()
+		e := readExchange(t, get(t, s, rawURL, testAccept))
+		var logBuf bytes.Buffer
+		payload, ok := e.Verify(time.Now(), s.fetchCertForVerification, log.New(&logBuf, "", 0))
+		if !ok {
+			t.Fatalf("%s is not verified: %s", rawURL, logBuf.String())
+		}
+		return payload
+	}
+
+	for query, size := range map[string]int{
+		"payload=random&size=3k":                              3 << 10,
+		"payload=random&size=3k&seed=7":                       3 << 10,
+		"payload=compressible&size=1m":                        1 << 20,
+		"payload=empty":                                       0,
+		"payload=boundary&records=2":                          2 * defaultMIRecordSize,
+		"payload=boundary&records=2&offset=-1&record_size=1k": 2<<10 - 1,
+		"payload=boundary&records=0&offset=1":                 1,
+		"payload=boundary&records=65536&record_size=16":       65536 * 16,
+		"payload=oversize&limit=10k":                          10<<10 + 1,
+	} {
+		payload := verify(t, "https://"+testHost+"/sxg/hello.sxg?"+query)
+		if len(payload) != size {
+			t.Errorf("%s: %d bytes, want %d", query, len(payload), size)
+		}
+	}
+	if !bytes.Equal(verify(t, "https://"+testHost+"/sxg/hello.sxg?payload=random&size=1k"), verify(t, "https://"+testHost+"/sxg/hello.sxg?payload=random&size=1k")) {
+		t.Error("the random payloads differ")
+	}
+	if bytes.Equal(verify(t, "https://"+testHost+"/sxg/hello.sxg?payload=random&size=1k"), verify(t, "https://"+testHost+"/sxg/hello.sxg?payload=random&size=1k&seed=1")) {
+		t.Error("the random payloads of the seeds are the same")
+	}
+
+	// The sub_ parameters give the synthetic payloads to the subresources,
+	// and the header-integrity follows them.
+	name := "amptestnocdn_js_img_preload.sxg"
+	rec := get(t, s, "https://"+testHost+"/sxg/"+name+"?sub_payload=boundary&sub_records=3&sub_offset=1", testAccept)
+	e := readExchange(t, rec)
+	checkAlternates(t, s, name, rec.Header()["Link"], e.ResponseHeaders["Link"])
+	links, err := parseLinks(rec.Header()["Link"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	alternates := 0
+	for _, l := range links {
+		if !l.hasRel("alternate") {
+			continue
+		}
+		alternates++
+		if !strings.Contains(l.target, "payload=boundary") {
+			t.Errorf("the alternate link %s has no synthetic payload", l.target)
+			continue
+		}
+		if payload := verify(t, l.target); len(payload) != 3*defaultMIRecordSize+1 {
+			t.Errorf("%s: %d bytes", l.target, len(payload))
+		}
+	}
+	if alternates == 0 {
+		t.Error("no alternate links")
+	}
+
+	// The auto exchanges take them too, as parents and as subresources.
+	if payload := verify(t, "https://"+testHost+"/sxg/auto/nikko_320.jpg.sxg?payload=random&size=1k"); len(payload) != 1<<10 {
+		t.Errorf("auto: %d bytes", len(payload))
+	}
+	name = "auto/amptestnocdn.html.sxg"
+	rec = get(t, s, "https://"+testHost+"/sxg/"+name+"?sub_payload=boundary&sub_records=2&sub_offset=3", testAccept)
+	e = readExchange(t, rec)
+	checkAlternates(t, s, name, rec.Header()["Link"], e.ResponseHeaders["Link"])
+	if links, err = parseLinks(rec.Header()["Link"]); err != nil {
+		t.Fatal(err)
+	}
+	alternates = 0
+	for _, l := range links {
+		if !l.hasRel("alternate") || !strings.Contains(l.target, autoSXGPathPrefix) {
+			continue
+		}
+		alternates++
+		if payload := verify(t, l.target); len(payload) != 2*defaultMIRecordSize+3 {
+			t.Errorf("%s: %d bytes", l.target, len(payload))
+		}
+	}
+	if alternates == 0 {
+		t.Error("no auto alternate links")
+	}
+
+	for _, query := range []string{
+		"payload=nope",
+		"payload=random&size=17m",
+		"payload=oversize&limit=16m",
+		"record_size=0",
+		"record_size=15",
+		"payload=random&size=16m&record_size=16",
+		"payload=boundary&records=65537&record_size=16",
+		"payload=boundary&records=1&offset=-4097",
+	} {
+		if rec := get(t, s, "https://"+testHost+"/sxg/hello.sxg?"+query, testAccept); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", query, rec.Code)
+		}
+	}
+}
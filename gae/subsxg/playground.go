@@ -0,0 +1,368 @@
+package subsxg
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+// The playground signs the exchanges submitted to playgroundAPIURLPath, and
+// serves them at playgroundSXGPathPrefix + <id>.sxg for playgroundTTL. The
+// subresources are served at playgroundSXGPathPrefix + <id>_<n>.sxg. Since
+// the exchanges are signed with the identities of the demo domains, the
+// playground is one of the admin pages.
+const (
+	playgroundURLPath       = "/playground"
+	playgroundAPIURLPath    = "/playground/api"
+	playgroundSXGPathPrefix = "/sxg/playground/"
+
+	playgroundTTL            = 30 * time.Minute
+	maxPlaygroundEntries     = 1000
+	maxPlaygroundRequestSize = 16 << 20
+	maxPlaygroundSubresource = 20
+)
+
+// A playgroundResource is an exchange submitted to the playground.
+type playgroundResource struct {
+	// Identity is the name of the signing identity, "default" or "alt".
+	Identity string `json:"identity"`
+	// Content is the path of the payload in the content store. Payload is
+	// used when it is empty.
+	Content      string      `json:"content"`
+	Payload      string      `json:"payload"`
+	ContentType  string      `json:"content_type"`
+	ContentURL   string      `json:"content_url"`
+	Status       int         `json:"status"`
+	InnerHeaders http.Header `json:"inner_headers"`
+	OuterHeaders http.Header `json:"outer_headers"`
+	// As and Crossorigin are the attributes of the preload link of a
+	// subresource.
+	As          string `json:"as"`
+	Crossorigin bool   `json:"crossorigin"`
+}
+
+type playgroundRequest struct {
+	playgroundResource
+	Subresources []playgroundResource `json:"subresources"`
+	// PermissiveHeaders lets the inner headers which break the signing rules
+	// through, like the "headers=permissive" query parameter.
+	PermissiveHeaders bool `json:"permissive_headers"`
+}
+
+type playgroundExchangeResult struct {
+	URL             string `json:"url"`
+	ContentURL      string `json:"content_url"`
+	HeaderIntegrity string `json:"header_integrity"`
+}
+
+type playgroundResponse struct {
+	URL        string                     `json:"url"`
+	Expires    time.Time                  `json:"expires"`
+	Exchanges  []playgroundExchangeResult `json:"exchanges"`
+	Inspection *inspection                `json:"inspection"`
+}
+
+// playgroundExchange is a resolved playgroundResource.
+type playgroundExchange struct {
+	identity     *Identity
+	contentURL   string
+	contentType  string
+	status       int
+	payload      []byte
+	innerHeaders http.Header
+	outerHeaders http.Header
+	as           string
+	crossorigin  bool
+	permissive   bool
+}
+
+// A playgroundEntry is the parent exchange and its subresources.
+type playgroundEntry struct {
+	exchanges []*playgroundExchange
+	expires   time.Time
+}
+
+type playgroundStore struct {
+	mu      sync.Mutex
+	entries map[string]*playgroundEntry
+}
+
+func newPlaygroundStore() *playgroundStore {
+	return &playgroundStore{entries: map[string]*playgroundEntry{}}
+}
+
+func (p *playgroundStore) get(id string, now time.Time) (*playgroundEntry, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	e, ok := p.entries[id]
+	if !ok || now.After(e.expires) {
+		delete(p.entries, id)
+		return nil, false
+	}
+	return e, true
+}
+
+// put stores e and returns its id. The expired entries are dropped first.
+func (p *playgroundStore) put(e *playgroundEntry, now time.Time) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, entry := range p.entries {
+		if now.After(entry.expires) {
+			delete(p.entries, id)
+		}
+	}
+	if len(p.entries) >= maxPlaygroundEntries {
+		return "", errors.New("the playground is full, try again later")
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	p.entries[id] = e
+	return id, nil
+}
+
+func (s *Server) identityByName(name string) (*Identity, bool) {
+	for _, id := range []*Identity{s.defaultIdentity, s.altIdentity} {
+		if name == "" && id == s.defaultIdentity || name == id.name {
+			return id, true
+		}
+	}
+	return nil, false
+}
+
+// resolvePlaygroundResource checks r and fills in the defaults: the payload
+// and the content type of the content, its URL on the identity and the 200
+// status. The content URL must be on the domain of the identity.
+func (s *Server) resolvePlaygroundResource(r *playgroundResource) (*playgroundExchange, error) {
+	id, ok := s.identityByName(r.Identity)
+	if !ok {
+		return nil, fmt.Errorf("unknown identity %q", r.Identity)
+	}
+	x := &playgroundExchange{
+		identity:     id,
+		contentURL:   r.ContentURL,
+		contentType:  r.ContentType,
+		status:       r.Status,
+		payload:      []byte(r.Payload),
+		innerHeaders: r.InnerHeaders.Clone(),
+		outerHeaders: r.OuterHeaders.Clone(),
+		as:           r.As,
+		crossorigin:  r.Crossorigin,
+	}
+	if r.Content != "" {
+		c, ok := s.contents.Get(strings.TrimPrefix(r.Content, "/"))
+		if !ok {
+			return nil, fmt.Errorf("unknown content %q", r.Content)
+		}
+		x.payload = c.payload
+		if x.contentType == "" {
+			x.contentType = c.contentType
+		}
+		if x.contentURL == "" {
+			x.contentURL = "https://" + id.domainName + "/" + c.path
+		}
+	}
+	if x.contentType == "" {
+		x.contentType = "text/html; charset=utf-8"
+	}
+	if x.status == 0 {
+		x.status = http.StatusOK
+	}
+	if x.status < 100 || x.status > 599 {
+		return nil, fmt.Errorf("invalid status %d", x.status)
+	}
+	if x.innerHeaders == nil {
+		x.innerHeaders = http.Header{}
+	}
+	u, err := url.Parse(x.contentURL)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("the content URL %q is not an https URL", x.contentURL)
+	}
+	if u.Hostname() != id.domainName {
+		return nil, fmt.Errorf("the content URL %q is not on %s of the %s identity", x.contentURL, id.domainName, id.name)
+	}
+	return x, nil
+}
+
+// setup sets up params to sign x. certHost is the host which serves the
+// cert-chain.
+func (x *playgroundExchange) setup(params *exchangeParams, certHost string) {
+	params.contentUrl = x.contentURL
+	params.certUrl = "https://" + certHost + x.identity.certURLPath
+	params.validityUrl = "https://" + x.identity.domainName + "/cert/null.validity.msg"
+	params.contentType = x.contentType
+	params.status = x.status
+	params.resHeader = x.innerHeaders.Clone()
+	params.payload = x.payload
+	params.identity = x.identity
+	params.permissiveHeaders = x.permissive
+}
+
+// headerIntegrity returns the header-integrity of the exchange of x.
+func (x *playgroundExchange) headerIntegrity() (string, error) {
+	params := &exchangeParams{
+		ver:        version.Version1b3,
+		recordSize: defaultMIRecordSize,
+	}
+	x.setup(params, x.identity.domainName)
+	return exchangeHeaderIntegrity(params)
+}
+
+func playgroundSXGURL(host string, id string, i int) string {
+	if i == 0 {
+		return "https://" + host + playgroundSXGPathPrefix + id + ".sxg"
+	}
+	return "https://" + host + playgroundSXGPathPrefix + id + "_" + strconv.Itoa(i) + ".sxg"
+}
+
+// newPlaygroundEntry resolves req. The allowed-alt-sxg and preload links of
+// the subresources are added to the inner headers of the parent.
+func (s *Server) newPlaygroundEntry(req *playgroundRequest) (*playgroundEntry, []string, error) {
+	if len(req.Subresources) > maxPlaygroundSubresource {
+		return nil, nil, fmt.Errorf("more than %d subresources", maxPlaygroundSubresource)
+	}
+	entry := &playgroundEntry{expires: s.now().Add(playgroundTTL)}
+	integrities := []string{""}
+	parent, err := s.resolvePlaygroundResource(&req.playgroundResource)
+	if err != nil {
+		return nil, nil, err
+	}
+	entry.exchanges = append(entry.exchanges, parent)
+	for i := range req.Subresources {
+		sub, err := s.resolvePlaygroundResource(&req.Subresources[i])
+		if err != nil {
+			return nil, nil, fmt.Errorf("subresource %d: %v", i+1, err)
+		}
+		if sub.as == "" {
+			return nil, nil, fmt.Errorf("subresource %d: no as of the preload link", i+1)
+		}
+		sub.permissive = req.PermissiveHeaders
+		integrity, err := sub.headerIntegrity()
+		if err != nil {
+			return nil, nil, fmt.Errorf("subresource %d: %v", i+1, err)
+		}
+		entry.exchanges = append(entry.exchanges, sub)
+		integrities = append(integrities, integrity)
+
+		parent.innerHeaders.Add("link", allowedAltSXGLink(sub.contentURL, integrity).String())
+		preload := preloadLink(sub.contentURL, sub.as)
+		if sub.crossorigin {
+			preload.params = append(preload.params, linkParam{name: "crossorigin", bare: true})
+		}
+		parent.innerHeaders.Add("link", preload.String())
+	}
+	parent.permissive = req.PermissiveHeaders
+	if integrities[0], err = parent.headerIntegrity(); err != nil {
+		return nil, nil, err
+	}
+	return entry, integrities, nil
+}
+
+// setupPlaygroundExchange sets up params to sign the exchange at path, which
+// is playgroundSXGPathPrefix + <id>.sxg or <id>_<n>.sxg.
+func (s *Server) setupPlaygroundExchange(params *exchangeParams, path string, r *http.Request) error {
+	name := strings.TrimSuffix(strings.TrimPrefix(path, playgroundSXGPathPrefix), ".sxg")
+	id, index := name, 0
+	if i := strings.IndexByte(name, '_'); i >= 0 {
+		n, err := strconv.Atoi(name[i+1:])
+		if err != nil {
+			return &exchangeError{status: http.StatusNotFound, msg: "setupPlaygroundExchange"}
+		}
+		id, index = name[:i], n
+	}
+	entry, ok := s.playground.get(id, s.now())
+	if !ok || index < 0 || index >= len(entry.exchanges) || !strings.HasSuffix(path, ".sxg") {
+		return &exchangeError{status: http.StatusNotFound, msg: "setupPlaygroundExchange"}
+	}
+
+	x := entry.exchanges[index]
+	x.setup(params, r.Host)
+	for name, values := range x.outerHeaders {
+		params.outerHeader[http.CanonicalHeaderKey(name)] = append(params.outerHeader[http.CanonicalHeaderKey(name)], values...)
+	}
+	if index == 0 {
+		for i, sub := range entry.exchanges[1:] {
+			params.outerHeader.Add("link", alternateLink(playgroundSXGURL(r.Host, id, i+1), sub.contentURL, sxgContentType(params.ver)).String())
+		}
+	}
+	return nil
+}
+
+// playgroundAPIHandler signs the playgroundRequest posted as JSON, and
+// responds with the URL of the exchange, the header-integrity values and the
+// inspection.
+func (s *Server) playgroundAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req playgroundRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPlaygroundRequestSize)).Decode(&req); err != nil {
+		http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry, integrities, err := s.newPlaygroundEntry(&req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	id, err := s.playground.put(entry, s.now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	res := &playgroundResponse{
+		URL:     playgroundSXGURL(r.Host, id, 0),
+		Expires: entry.expires,
+	}
+	for i, x := range entry.exchanges {
+		res.Exchanges = append(res.Exchanges, playgroundExchangeResult{
+			URL:             playgroundSXGURL(r.Host, id, i),
+			ContentURL:      x.contentURL,
+			HeaderIntegrity: integrities[i],
+		})
+	}
+	u, _ := url.Parse(res.URL)
+	res.Inspection = s.inspect(u)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(res)
+}
+
+func (s *Server) playgroundHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(w, r) {
+		return
+	}
+	data := struct {
+		Contents   []string
+		Identities []string
+		TTL        time.Duration
+	}{
+		Contents:   s.contents.Paths(),
+		Identities: []string{s.defaultIdentity.name, s.altIdentity.name},
+		TTL:        playgroundTTL,
+	}
+	if err := templates.ExecuteTemplate(w, "playground.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,107 @@
+package subsxg
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAdminPassword = "admin"
+
+func postPlayground(t *testing.T, s *Server, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "https://"+testHost+playgroundAPIURLPath, strings.NewReader(body))
+	req.SetBasicAuth("admin", testAdminPassword)
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestPlayground(t *testing.T) {
+	now := time.Now()
+	s := newTestServer(t, WithClock(func() time.Time { return now }), WithAdminPassword(testAdminPassword))
+	rec := postPlayground(t, s, `{
+		"payload": "<!DOCTYPE html><script src=\"https://demo.test/v0.js\"></script>",
+		"content_url": "https://demo.test/playground.html",
+		"inner_headers": {"Cache-Control": ["public, max-age=600"]},
+		"outer_headers": {"X-Playground": ["1"]},
+		"subresources": [{
+			"content": "v0.js",
+			"inner_headers": {"Cache-Control": ["public, max-age=600"]},
+			"as": "script"
+		}]
+	}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body.String())
+	}
+	var res playgroundResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Exchanges) != 2 || res.Exchanges[1].ContentURL != "https://"+testDomainName+"/v0.js" {
+		t.Fatalf("exchanges %+v", res.Exchanges)
+	}
+	if !res.Inspection.Verified || len(res.Inspection.LinkProblems) != 0 {
+		t.Errorf("inspection %+v", res.Inspection)
+	}
+
+	parent := get(t, s, res.URL, testAccept)
+	if parent.Header().Get("X-Playground") != "1" {
+		t.Errorf("no outer header")
+	}
+	e := readExchange(t, parent)
+	if e.RequestURI != "https://demo.test/playground.html" {
+		t.Errorf("content URL %s", e.RequestURI)
+	}
+	if got := headerIntegrityOf(t, e); got != res.Exchanges[0].HeaderIntegrity {
+		t.Errorf("header-integrity %s, want %s", got, res.Exchanges[0].HeaderIntegrity)
+	}
+	checkAlternates(t, s, "playground", parent.Header()["Link"], e.ResponseHeaders["Link"])
+
+	child := readExchange(t, get(t, s, res.Exchanges[1].URL, testAccept))
+	if _, ok := child.Verify(now, s.fetchCertForVerification, log.New(&bytes.Buffer{}, "", 0)); !ok {
+		t.Errorf("subresource is not verified")
+	}
+
+	now = now.Add(playgroundTTL + time.Second)
+	if rec := get(t, s, res.URL, testAccept); rec.Code != http.StatusNotFound {
+		t.Errorf("expired exchange responded with %d", rec.Code)
+	}
+}
+
+func TestPlaygroundErrors(t *testing.T) {
+	s := newTestServer(t, WithAdminPassword(testAdminPassword))
+	for _, body := range []string{
+		`{`,
+		`{"identity": "unknown", "content_url": "https://demo.test/"}`,
+		`{"content": "unknown.html"}`,
+		`{"content_url": "http://demo.test/"}`,
+		`{"content_url": "https://demo.test/", "status": 1000}`,
+		`{"content_url": "https://demo.test/", "inner_headers": {"Set-Cookie": ["a=b"]}}`,
+		`{"content_url": "https://demo.test/", "subresources": [{"content": "v0.js"}]}`,
+		`{"content_url": "https://example.com/"}`,
+		`{"identity": "alt", "content_url": "https://demo.test/"}`,
+	} {
+		if rec := postPlayground(t, s, body); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d", body, rec.Code)
+		}
+	}
+
+	// The playground is one of the admin pages.
+	for _, path := range []string{playgroundURLPath, playgroundAPIURLPath} {
+		req := httptest.NewRequest(http.MethodPost, "https://"+testHost+path, strings.NewReader(`{"content": "v0.js"}`))
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s without the password: status %d", path, rec.Code)
+		}
+	}
+	if rec := postPlayground(t, newTestServer(t), `{"content": "v0.js"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("the playground without the admin password: status %d", rec.Code)
+	}
+}
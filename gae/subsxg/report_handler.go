@@ -0,0 +1,194 @@
+package subsxg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	reportsURLPath     = "/reports"
+	reportingGroupName = "sxg-reports"
+	maxStoredReports   = 1000
+	maxReportsBodySize = 1 << 20
+)
+
+type sxgReport struct {
+	Received  time.Time
+	Scenario  string
+	UserAgent string
+	Type      string
+	ErrorCode string
+	OuterURL  string
+	InnerURL  string
+	CertURLs  []string
+}
+
+// networkErrorReport is a report delivered with the application/reports+json
+// content type. Signed exchange failures are reported with the "sxg" phase.
+type networkErrorReport struct {
+	Type      string `json:"type"`
+	URL       string `json:"url"`
+	UserAgent string `json:"user_agent"`
+	Body      struct {
+		Phase string `json:"phase"`
+		Type  string `json:"type"`
+		SXG   *struct {
+			OuterURL string   `json:"outer_url"`
+			InnerURL string   `json:"inner_url"`
+			CertURL  []string `json:"cert_url"`
+		} `json:"sxg"`
+	} `json:"body"`
+}
+
+type reportStore struct {
+	mu      sync.Mutex
+	reports []sxgReport
+}
+
+func (s *reportStore) add(r sxgReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reports = append(s.reports, r)
+	if len(s.reports) > maxStoredReports {
+		s.reports = s.reports[len(s.reports)-maxStoredReports:]
+	}
+}
+
+func (s *reportStore) list() []sxgReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]sxgReport(nil), s.reports...)
+}
+
+// addReportingHeaders makes the response carry Report-To and NEL headers
+// which point to reportsURLPath when the reporting is enabled.
+func (s *Server) addReportingHeaders(w http.ResponseWriter, r *http.Request) {
+	if !s.reportingEnabled {
+		return
+	}
+	reportTo, _ := json.Marshal(map[string]interface{}{
+		"group":     reportingGroupName,
+		"max_age":   86400,
+		"endpoints": []map[string]string{{"url": "https://" + r.Host + reportsURLPath}},
+	})
+	nel, _ := json.Marshal(map[string]interface{}{
+		"report_to":        reportingGroupName,
+		"max_age":          86400,
+		"success_fraction": 0.0,
+		"failure_fraction": 1.0,
+	})
+	w.Header().Set("Report-To", string(reportTo))
+	w.Header().Set("NEL", string(nel))
+}
+
+// scenarioName returns the name of the scenario (e.g. "fonttest.sxg") which
+// served outerURL.
+func scenarioName(outerURL string) string {
+	u, err := url.Parse(outerURL)
+	if err != nil || !strings.HasPrefix(u.Path, "/sxg/") {
+		return outerURL
+	}
+	return path.Base(u.Path)
+}
+
+func parseReports(body []byte, received time.Time) ([]sxgReport, error) {
+	var nelReports []networkErrorReport
+	if err := json.Unmarshal(body, &nelReports); err != nil {
+		return nil, err
+	}
+	var parsed []sxgReport
+	for _, nr := range nelReports {
+		if nr.Type != "network-error" || nr.Body.SXG == nil {
+			continue
+		}
+		parsed = append(parsed, sxgReport{
+			Received:  received,
+			Scenario:  scenarioName(nr.Body.SXG.OuterURL),
+			UserAgent: nr.UserAgent,
+			Type:      nr.Type,
+			ErrorCode: nr.Body.Type,
+			OuterURL:  nr.Body.SXG.OuterURL,
+			InnerURL:  nr.Body.SXG.InnerURL,
+			CertURLs:  nr.Body.SXG.CertURL,
+		})
+	}
+	return parsed, nil
+}
+
+func (s *Server) reportsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "POST")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxReportsBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		parsed, err := parseReports(body, s.now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, report := range parsed {
+			s.reports.add(report)
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		s.showReports(w, r)
+	default:
+		http.Error(w, "reportsHandler", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) showReports(w http.ResponseWriter, r *http.Request) {
+	type UserAgentGroup struct {
+		UserAgent string
+		Reports   []sxgReport
+	}
+	type ScenarioGroup struct {
+		Scenario   string
+		UserAgents []*UserAgentGroup
+	}
+
+	groups := map[string]map[string]*UserAgentGroup{}
+	for _, report := range s.reports.list() {
+		if groups[report.Scenario] == nil {
+			groups[report.Scenario] = map[string]*UserAgentGroup{}
+		}
+		uaGroup := groups[report.Scenario][report.UserAgent]
+		if uaGroup == nil {
+			uaGroup = &UserAgentGroup{UserAgent: report.UserAgent}
+			groups[report.Scenario][report.UserAgent] = uaGroup
+		}
+		uaGroup.Reports = append(uaGroup.Reports, report)
+	}
+
+	var data []ScenarioGroup
+	for scenario, uaGroups := range groups {
+		group := ScenarioGroup{Scenario: scenario}
+		for _, uaGroup := range uaGroups {
+			group.UserAgents = append(group.UserAgents, uaGroup)
+		}
+		sort.Slice(group.UserAgents, func(i, j int) bool {
+			return group.UserAgents[i].UserAgent < group.UserAgents[j].UserAgent
+		})
+		data = append(data, group)
+	}
+	sort.Slice(data, func(i, j int) bool { return data[i].Scenario < data[j].Scenario })
+
+	if err := templates.ExecuteTemplate(w, "reports.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,527 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package subsxg
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// A Scenario is a test case served at /sxg/<Name>.
+type Scenario struct {
+	Name string
+	// Listed scenarios are shown on the index page. The others are the
+	// subresources of the listed ones.
+	Listed bool
+	// ListEarlyHints lists the variant served with 103 Early Hints too.
+	ListEarlyHints bool
+	// Expected is the browser behavior which the spec expects, if the
+	// scenario is a negative test.
+	Expected string
+	// Outcome is what the browser does with the exchange, and Subresources
+	// what it does with the alternate exchanges, by the scenario names.
+	Outcome      Outcome
+	Subresources map[string]Outcome
+	// The shapings of the exchange, of the alternate exchanges of the
+	// subresources and of the cert-chain. The query parameters override
+	// them.
+	Shaping            Shaping
+	SubresourceShaping Shaping
+	CertShaping        Shaping
+	// setup changes params from the default hello.html exchange, and adds
+	// the outer response headers to w.
+	setup func(s *Server, params *exchangeParams, r *http.Request)
+}
+
+// imageVariants is the Variants of the images which are served as JPEG or
+// WebP by the Accept header.
+const imageVariants = "accept;image/jpeg;image/webp"
+
+// dropFirstPayloadByte breaks the header-integrity of the alternate exchanges
+// of the _error scenarios.
+func dropFirstPayloadByte(params *exchangeParams) {
+	params.payload = params.payload[1:]
+}
+
+// DefaultScenarios returns all the test cases.
+func DefaultScenarios() []Scenario {
+	scenarios := []Scenario{
+		{
+			Name:   "hello.sxg",
+			Listed: true,
+			setup:  func(s *Server, params *exchangeParams, r *http.Request) {},
+		},
+		{
+			Name:           "hello_certpush.sxg",
+			Listed:         true,
+			ListEarlyHints: true,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.outerHeader.Add("link", preloadLink(s.defaultIdentity.certURLPath, "fetch").String())
+			},
+		},
+		{
+			Name:   "hello_data_url_cert.sxg",
+			Listed: true,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.certUrl = "data:application/cert-chain+cbor;base64," + base64.StdEncoding.EncodeToString(s.defaultIdentity.getCertMessage())
+			},
+		},
+		{
+			Name:   "alt.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.identity = s.altIdentity
+				params.contentUrl = "https://" + s.altDemoDomainName + "/hello.html"
+				params.certUrl = "https://" + r.Host + s.altIdentity.certURLPath
+				params.validityUrl = "https://" + s.altDemoDomainName + "/cert/null.validity.msg"
+				params.resHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "nosniff_alt.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.identity = s.altIdentity
+				params.contentUrl = "https://" + s.altDemoDomainName + "/hello.html"
+				params.certUrl = "https://" + r.Host + s.altIdentity.certURLPath
+				params.validityUrl = "https://" + s.altDemoDomainName + "/cert/null.validity.msg"
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.resHeader.Add("X-Content-Type-Options", "nosniff")
+			},
+		},
+		{
+			Name:   "nosniffable_alt.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.identity = s.altIdentity
+				params.contentUrl = "https://" + s.altDemoDomainName + "/hello.html"
+				params.certUrl = "https://" + r.Host + s.altIdentity.certURLPath
+				params.validityUrl = "https://" + s.altDemoDomainName + "/cert/null.validity.msg"
+				params.payload = []byte(nosniffablePayload)
+				params.resHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "wapuro-mincho.woff2.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.identity = s.altIdentity
+				params.contentUrl = "https://" + s.altDemoDomainName + "/fonts/wapuro-mincho.woff2"
+				params.certUrl = "https://" + r.Host + s.altIdentity.certURLPath
+				params.validityUrl = "https://" + s.altDemoDomainName + "/cert/null.validity.msg"
+				params.payload = s.contentPayload("wapuro-mincho.woff2")
+				params.contentType = "font/woff2"
+				params.resHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "fonttest.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"wapuro-mincho.woff2.sxg": OutcomeCORSBlocked,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/fonttest.html"
+				params.payload = s.contentPayload("fonttest.html")
+
+				fontURL := "https://" + s.altDemoDomainName + "/fonts/wapuro-mincho.woff2"
+				sxgURL := "https://" + r.Host + "/sxg/wapuro-mincho.woff2.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, fontURL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(fontURL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", fontPreloadLink(fontURL).String())
+			},
+		},
+		{
+			Name:   "cors_wapuro-mincho.woff2.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.identity = s.altIdentity
+				params.contentUrl = "https://" + s.altDemoDomainName + "/fonts/wapuro-mincho.woff2"
+				params.certUrl = "https://" + r.Host + s.altIdentity.certURLPath
+				params.validityUrl = "https://" + s.altDemoDomainName + "/cert/null.validity.msg"
+				params.payload = s.contentPayload("wapuro-mincho.woff2")
+				params.contentType = "font/woff2"
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.resHeader.Add("Access-Control-Allow-Origin", "*")
+			},
+		},
+		{
+			Name:   "cors_fonttest.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"cors_wapuro-mincho.woff2.sxg": OutcomeSXG,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/fonttest.html"
+				params.payload = s.contentPayload("fonttest.html")
+
+				fontURL := "https://" + s.altDemoDomainName + "/fonts/wapuro-mincho.woff2"
+				sxgURL := "https://" + r.Host + "/sxg/cors_wapuro-mincho.woff2.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, fontURL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(fontURL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", fontPreloadLink(fontURL).String())
+			},
+		},
+		{
+			Name:   "corbtest.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"alt.sxg": OutcomeCORBBlocked,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/corb_test.html"
+				params.payload = s.contentPayload("corbtest.html")
+
+				helloURL := "https://" + s.altDemoDomainName + "/hello.html"
+				sxgURL := "https://" + r.Host + "/sxg/alt.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, helloURL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(helloURL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", preloadLink(helloURL, "script").String())
+			},
+		},
+		{
+			Name:   "nosniff_corbtest.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"nosniff_alt.sxg": OutcomeCORBBlocked,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/corb_test.html"
+				params.payload = s.contentPayload("corbtest.html")
+
+				helloURL := "https://" + s.altDemoDomainName + "/hello.html"
+				sxgURL := "https://" + r.Host + "/sxg/nosniff_alt.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, helloURL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(helloURL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", preloadLink(helloURL, "script").String())
+			},
+		},
+		{
+			Name:   "nosniffable_corbtest.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"nosniffable_alt.sxg": OutcomeSXG,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/corb_test.html"
+				params.payload = s.contentPayload("corbtest.html")
+
+				helloURL := "https://" + s.altDemoDomainName + "/hello.html"
+				sxgURL := "https://" + r.Host + "/sxg/nosniffable_alt.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, helloURL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(helloURL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", preloadLink(helloURL, "script").String())
+			},
+		},
+		{
+			Name:   "amptestnocdn.sxg",
+			Listed: true,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		{
+			Name:   "amptestnocdn_js_preload.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"v0.sxg": OutcomeSXG,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				v0URL := "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				sxgURL := "https://" + r.Host + "/sxg/v0.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, v0URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(v0URL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", preloadLink(v0URL, "script").String())
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		{
+			Name:   "amptestnocdn_js_img_preload.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"v0.sxg":            OutcomeSXG,
+				"nikko_320_jpg.sxg": OutcomeSXG,
+				"nikko_640_jpg.sxg": OutcomeSXG,
+			},
+			ListEarlyHints: true,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				v0URL := "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				v0SXGURL := "https://" + r.Host + "/sxg/v0.sxg"
+				params.outerHeader.Add("link", alternateLink(v0SXGURL, v0URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(v0URL, s.childHeaderIntegrity(r, v0SXGURL)).String())
+				params.resHeader.Add("link", preloadLink(v0URL, "script").String())
+
+				nikko320URL := "https://" + s.demoDomainName + "/amptest/img/nikko_320.jpg"
+				nikko640URL := "https://" + s.demoDomainName + "/amptest/img/nikko_640.jpg"
+				nikko320SXGURL := "https://" + r.Host + "/sxg/nikko_320_jpg.sxg"
+				nikko640SXGURL := "https://" + r.Host + "/sxg/nikko_640_jpg.sxg"
+				params.outerHeader.Add("link", alternateLink(nikko320SXGURL, nikko320URL, sxgContentType(params.ver)).String())
+				params.outerHeader.Add("link", alternateLink(nikko640SXGURL, nikko640URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(nikko320URL, s.childHeaderIntegrity(r, nikko320SXGURL)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(nikko640URL, s.childHeaderIntegrity(r, nikko640SXGURL)).String())
+				params.resHeader.Add("link", nikkoPreloadLink(nikko320URL, nikko640URL).String())
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		{
+			Name:   "amptestnocdn_js_img_vary_preload.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"v0.sxg":             OutcomeSXG,
+				"nikko_320_jpg.sxg":  OutcomeUnused,
+				"nikko_320_webp.sxg": OutcomeSXG,
+				"nikko_640_jpg.sxg":  OutcomeUnused,
+				"nikko_640_webp.sxg": OutcomeSXG,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				v0URL := "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				v0SXGURL := "https://" + r.Host + "/sxg/v0.sxg"
+				params.outerHeader.Add("link", alternateLink(v0SXGURL, v0URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(v0URL, s.childHeaderIntegrity(r, v0SXGURL)).String())
+				params.resHeader.Add("link", preloadLink(v0URL, "script").String())
+
+				nikko320URL := "https://" + s.demoDomainName + "/amptest/img/nikko_320.jpg"
+				nikko640URL := "https://" + s.demoDomainName + "/amptest/img/nikko_640.jpg"
+				images := []struct {
+					url     string
+					sxgName string
+					key     string
+				}{
+					{nikko320URL, "nikko_320_jpg.sxg", "image/jpeg"},
+					{nikko320URL, "nikko_320_webp.sxg", "image/webp"},
+					{nikko640URL, "nikko_640_jpg.sxg", "image/jpeg"},
+					{nikko640URL, "nikko_640_webp.sxg", "image/webp"},
+				}
+				for _, img := range images {
+					sxgURL := "https://" + r.Host + "/sxg/" + img.sxgName
+					params.outerHeader.Add("link", alternateLink(sxgURL, img.url, sxgContentType(params.ver), variantParams(imageVariants, img.key)...).String())
+				}
+				for _, img := range images {
+					sxgURL := "https://" + r.Host + "/sxg/" + img.sxgName
+					params.resHeader.Add("link", allowedAltSXGLink(img.url, s.childHeaderIntegrity(r, sxgURL), variantParams(imageVariants, img.key)...).String())
+				}
+				params.resHeader.Add("link", nikkoPreloadLink(nikko320URL, nikko640URL).String())
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		{
+			Name:   "amptestnocdn_js_preload_error.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"v0.sxg": OutcomeFallback,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				v0URL := "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				sxgURL := "https://" + r.Host + "/sxg/v0.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, v0URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(v0URL, s.childHeaderIntegrity(r, sxgURL, dropFirstPayloadByte)).String())
+				params.resHeader.Add("link", preloadLink(v0URL, "script").String())
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		{
+			Name:   "amptestnocdn_js_img_preload_error.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"v0.sxg":            OutcomeSXG,
+				"nikko_320_jpg.sxg": OutcomeFallback,
+				"nikko_640_jpg.sxg": OutcomeFallback,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				v0URL := "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				v0SXGURL := "https://" + r.Host + "/sxg/v0.sxg"
+				params.outerHeader.Add("link", alternateLink(v0SXGURL, v0URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(v0URL, s.childHeaderIntegrity(r, v0SXGURL)).String())
+				params.resHeader.Add("link", preloadLink(v0URL, "script").String())
+
+				nikko320URL := "https://" + s.demoDomainName + "/amptest/img/nikko_320.jpg"
+				nikko640URL := "https://" + s.demoDomainName + "/amptest/img/nikko_640.jpg"
+				nikko320SXGURL := "https://" + r.Host + "/sxg/nikko_320_jpg.sxg"
+				nikko640SXGURL := "https://" + r.Host + "/sxg/nikko_640_jpg.sxg"
+				params.outerHeader.Add("link", alternateLink(nikko320SXGURL, nikko320URL, sxgContentType(params.ver)).String())
+				params.outerHeader.Add("link", alternateLink(nikko640SXGURL, nikko640URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(nikko320URL, s.childHeaderIntegrity(r, nikko320SXGURL, dropFirstPayloadByte)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(nikko640URL, s.childHeaderIntegrity(r, nikko640SXGURL, dropFirstPayloadByte)).String())
+				params.resHeader.Add("link", nikkoPreloadLink(nikko320URL, nikko640URL).String())
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		{
+			Name:   "v0.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				params.contentType = "text/javascript"
+				params.payload = s.contentPayload("v0.js")
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.outerHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "nikko_320_jpg.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/img/nikko_320.jpg"
+				params.contentType = "image/jpeg"
+				params.payload = s.contentPayload("nikko_320.jpg")
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.outerHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "nikko_320_webp.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/img/nikko_320.jpg"
+				params.contentType = "image/webp"
+				params.payload = s.contentPayload("nikko_320.webp")
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.outerHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "nikko_640_jpg.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/img/nikko_640.jpg"
+				params.contentType = "image/jpeg"
+				params.payload = s.contentPayload("nikko_640.jpg")
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.outerHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "nikko_640_webp.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/img/nikko_640.jpg"
+				params.contentType = "image/webp"
+				params.payload = s.contentPayload("nikko_640.webp")
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.outerHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "loop.sxg",
+			Listed: true,
+			Subresources: map[string]Outcome{
+				"a_css.sxg": OutcomeSXG,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				cssURL := "https://" + s.demoDomainName + "/amptest/css/a.css"
+				sxgURL := "https://" + r.Host + "/sxg/a_css.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, cssURL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(cssURL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", preloadLink(cssURL, "style").String())
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		{
+			Name:   "a_css.sxg",
+			Listed: false,
+			Subresources: map[string]Outcome{
+				"b_css.sxg": OutcomeFallback,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/css/a.css"
+				params.contentType = "text/css"
+				params.payload = []byte("")
+				cssURL := "https://" + s.demoDomainName + "/amptest/css/b.css"
+				sxgURL := "https://" + r.Host + "/sxg/b_css.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, cssURL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(cssURL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", preloadLink(cssURL, "style").String())
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.outerHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+		{
+			Name:   "b_css.sxg",
+			Listed: false,
+			Subresources: map[string]Outcome{
+				"a_css.sxg": OutcomeFallback,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/css/b.css"
+				params.contentType = "text/css"
+				params.payload = []byte("")
+				cssURL := "https://" + s.demoDomainName + "/amptest/css/a.css"
+				sxgURL := "https://" + r.Host + "/sxg/a_css.sxg"
+				params.outerHeader.Add("link", alternateLink(sxgURL, cssURL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(cssURL, s.childHeaderIntegrity(r, sxgURL)).String())
+				params.resHeader.Add("link", preloadLink(cssURL, "style").String())
+				params.resHeader.Add("cache-control", "public, max-age=600")
+				params.outerHeader.Add("cache-control", "public, max-age=600")
+			},
+		},
+	}
+	scenarios = append(scenarios,
+		shapedVariant(scenarios, "hello_certpush.sxg", "hello_certpush_slow_cert.sxg", func(sc *Scenario) {
+			sc.CertShaping = Shaping{FirstByteDelay: 2 * time.Second}
+		}),
+		shapedVariant(scenarios, "amptestnocdn_js_img_preload.sxg", "amptestnocdn_js_img_preload_slow_subresources.sxg", func(sc *Scenario) {
+			sc.SubresourceShaping = Shaping{FirstByteDelay: 2 * time.Second, BytesPerSecond: 100000}
+		}),
+		shapedVariant(scenarios, "amptestnocdn_js_img_preload.sxg", "amptestnocdn_js_img_preload_stalled.sxg", func(sc *Scenario) {
+			sc.Shaping = Shaping{StallAt: 1024, StallFor: 3 * time.Second}
+		}),
+	)
+	scenarios = append(scenarios, forbiddenHeaderScenarios()...)
+	return append(scenarios, statusScenarios()...)
+}
+
+// shapedVariant returns a copy of the scenario named base in scenarios, which
+// is renamed to name and shaped by shape.
+func shapedVariant(scenarios []Scenario, base string, name string, shape func(sc *Scenario)) Scenario {
+	for _, sc := range scenarios {
+		if sc.Name == base {
+			sc.Name = name
+			sc.ListEarlyHints = false
+			shape(&sc)
+			return sc
+		}
+	}
+	panic("shapedVariant: no scenario " + base)
+}
+
+// shapingSummary describes the shapings of sc on the index page.
+func (sc *Scenario) shapingSummary() string {
+	var s []string
+	if !sc.Shaping.isZero() {
+		s = append(s, "exchange: "+sc.Shaping.String())
+	}
+	if !sc.SubresourceShaping.isZero() {
+		s = append(s, "subresources: "+sc.SubresourceShaping.String())
+	}
+	if !sc.CertShaping.isZero() {
+		s = append(s, "cert: "+sc.CertShaping.String())
+	}
+	return strings.Join(s, "; ")
+}
+
+// fontPreloadLink returns the preload link of a WOFF2 font, which is fetched
+// in CORS mode.
+func fontPreloadLink(fontURL string) *link {
+	return preloadLink(fontURL, "font",
+		linkParam{name: "type", value: "font/woff2"},
+		linkParam{name: "crossorigin", bare: true})
+}
+
+// nikkoPreloadLink returns the preload link of the responsive image of
+// amptestnocdn.html.
+func nikkoPreloadLink(nikko320URL string, nikko640URL string) *link {
+	return preloadLink(nikko640URL, "image",
+		linkParam{name: "imagesrcset", value: nikko640URL + " 640w, " + nikko320URL + " 320w"},
+		linkParam{name: "imagesizes", value: "(max-width: 640px) 100vw, 640px"})
+}
@@ -0,0 +1,246 @@
+// Package subsxg serves the subresource signed exchange test cases. A Server
+// is an http.Handler, so it can be run in process from Go tests as well as by
+// the App Engine app.
+package subsxg
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var templates = template.Must(template.ParseFS(templateFiles, "templates/*.html"))
+
+// Server serves the signed exchanges at /sxg/, the cert-chains at /cert/ and
+// the index page, along with the reports, the cache emulator, the admin pages,
+// the metrics, the inspection pages, the HAR exports and the playground.
+type Server struct {
+	defaultIdentity *Identity
+	altIdentity     *Identity
+
+	demoDomainName    string
+	altDemoDomainName string
+
+	contents      ContentStore
+	scenarios     []Scenario
+	scenarioNames map[string]*Scenario
+	archives      []archiveImport
+	bundles       []bundleImport
+
+	now  func() time.Time
+	rand io.Reader
+
+	reportingEnabled bool
+	reports          *reportStore
+	cache            *exchangeCache
+	playground       *playgroundStore
+	adminPassword    string
+
+	logMu     sync.Mutex
+	logOutput io.Writer
+	metrics   *serverMetrics
+
+	mux *http.ServeMux
+}
+
+// An Option configures a Server.
+type Option func(s *Server)
+
+// WithIdentity sets the identity which signs the exchanges for the demo
+// domain, which is the subject of its certificate.
+func WithIdentity(id *Identity) Option {
+	return func(s *Server) { s.defaultIdentity = id }
+}
+
+// WithAltIdentity sets the identity which signs the cross-origin exchanges.
+func WithAltIdentity(id *Identity) Option {
+	return func(s *Server) { s.altIdentity = id }
+}
+
+// WithContentStore sets the contents which the scenarios and the auto signed
+// exchanges sign.
+func WithContentStore(contents ContentStore) Option {
+	return func(s *Server) { s.contents = contents }
+}
+
+// WithScenarios replaces DefaultScenarios.
+func WithScenarios(scenarios ...Scenario) Option {
+	return func(s *Server) { s.scenarios = scenarios }
+}
+
+// WithClock sets the clock which the signatures are dated with.
+func WithClock(now func() time.Time) Option {
+	return func(s *Server) { s.now = now }
+}
+
+// WithRand sets the source of randomness of the signatures. Deterministic
+// sources make the exchanges reproducible.
+func WithRand(rand io.Reader) Option {
+	return func(s *Server) { s.rand = rand }
+}
+
+// WithReporting makes the .sxg and the cert-chain responses carry Report-To
+// and NEL headers.
+func WithReporting(enabled bool) Option {
+	return func(s *Server) { s.reportingEnabled = enabled }
+}
+
+// WithAdminPassword enables the admin pages with the basic authentication.
+func WithAdminPassword(password string) Option {
+	return func(s *Server) { s.adminPassword = password }
+}
+
+// WithLogOutput sets where the structured signing logs are written. They are
+// written to stdout by default.
+func WithLogOutput(w io.Writer) Option {
+	return func(s *Server) { s.logOutput = w }
+}
+
+// New returns a Server. The identity and the alt identity are required.
+func New(opts ...Option) (*Server, error) {
+	s := &Server{
+		contents:   NewMemContentStore(nil),
+		scenarios:  DefaultScenarios(),
+		now:        time.Now,
+		reports:    &reportStore{},
+		cache:      newExchangeCache(),
+		playground: newPlaygroundStore(),
+		logOutput:  os.Stdout,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.defaultIdentity == nil || s.altIdentity == nil {
+		return nil, errors.New("subsxg: both the identity and the alt identity are required")
+	}
+	s.demoDomainName = s.defaultIdentity.domainName
+	s.altDemoDomainName = s.altIdentity.domainName
+	s.metrics = newServerMetrics(s.defaultIdentity, s.altIdentity)
+	for _, im := range s.archives {
+		if err := s.importArchive(im); err != nil {
+			return nil, fmt.Errorf("subsxg: failed to import the archive: %v", err)
+		}
+	}
+	for _, im := range s.bundles {
+		if err := s.importBundle(im); err != nil {
+			return nil, fmt.Errorf("subsxg: failed to import the bundle: %v", err)
+		}
+	}
+
+	s.scenarioNames = map[string]*Scenario{}
+	for i := range s.scenarios {
+		s.scenarioNames[s.scenarios[i].Name] = &s.scenarios[i]
+	}
+
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/cert/", s.certHandler)
+	s.mux.HandleFunc("/sxg/", s.signedExchangeHandler)
+	s.mux.HandleFunc(reportsURLPath, s.reportsHandler)
+	s.mux.HandleFunc(cachePathPrefix, s.cacheHandler)
+	s.mux.HandleFunc(adminURLPath, s.adminHandler)
+	s.mux.HandleFunc(adminStatusURLPath, s.adminHandler)
+	s.mux.HandleFunc(metricsURLPath, s.metricsHandler)
+	s.mux.HandleFunc(inspectPathPrefix, s.inspectHandler)
+	s.mux.HandleFunc(harPathPrefix, s.harHandler)
+	s.mux.HandleFunc(playgroundURLPath, s.playgroundHandler)
+	s.mux.HandleFunc(playgroundAPIURLPath, s.playgroundAPIHandler)
+	s.mux.HandleFunc(integrityURLPath, s.integrityHandler)
+	s.mux.HandleFunc("/", s.indexHandler)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// DemoDomainName returns the domain name of the identity.
+func (s *Server) DemoDomainName() string {
+	return s.demoDomainName
+}
+
+// RefreshOCSPPeriodically keeps the OCSP responses of the identities fresh.
+// It never returns.
+func (s *Server) RefreshOCSPPeriodically() {
+	for range time.Tick(ocspRefreshInterval) {
+		for _, id := range []*Identity{s.defaultIdentity, s.altIdentity} {
+			if id.needsOCSPRefresh(s.now()) {
+				id.refreshOCSP()
+			}
+		}
+	}
+}
+
+// contentPayload returns the payload of p in the content store, or nil if it
+// doesn't exist.
+func (s *Server) contentPayload(p string) []byte {
+	c, ok := s.contents.Get(p)
+	if !ok {
+		return nil
+	}
+	return c.payload
+}
+
+func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	type SXG struct {
+		Name     string
+		Expected string
+		Outcomes string
+		Shaping  string
+	}
+	type Data struct {
+		Host              string
+		ReportingEnabled  bool
+		PlaygroundEnabled bool
+		SXGs              []SXG
+		AutoSXGs          []string
+		ShapingParams     []string
+		PayloadParams     []string
+	}
+	data := Data{
+		Host:              r.Host,
+		ReportingEnabled:  s.reportingEnabled,
+		PlaygroundEnabled: s.adminPassword != "",
+	}
+	for _, prefix := range []string{"", subShapingPrefix, certShapingPrefix} {
+		for _, param := range []string{delayParam, bpsParam, stallAtParam, stallParam} {
+			data.ShapingParams = append(data.ShapingParams, prefix+param)
+		}
+	}
+	for _, prefix := range []string{"", subShapingPrefix} {
+		for _, param := range payloadParams {
+			data.PayloadParams = append(data.PayloadParams, prefix+param)
+		}
+	}
+	for _, scenario := range s.scenarios {
+		if !scenario.Listed {
+			continue
+		}
+		data.SXGs = append(data.SXGs, SXG{scenario.Name, scenario.Expected, scenario.expectationSummary(), scenario.shapingSummary()})
+		if scenario.ListEarlyHints {
+			data.SXGs = append(data.SXGs, SXG{strings.TrimSuffix(scenario.Name, ".sxg") + earlyHintsSuffix, scenario.Expected, scenario.expectationSummary(), scenario.shapingSummary()})
+		}
+	}
+
+	for _, p := range s.contents.Paths() {
+		data.AutoSXGs = append(data.AutoSXGs, strings.TrimPrefix(autoSXGPathPrefix, "/sxg/")+p+".sxg")
+	}
+
+	if err := templates.ExecuteTemplate(w, "index.html", data); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
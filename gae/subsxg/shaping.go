@@ -0,0 +1,226 @@
+package subsxg
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The longest delay of a shaped response, so that a typo doesn't hang the
+// test runner. The body is throttled and stalled for maxShapingDuration at
+// most, and the rest of it is written at once, so that a low bps doesn't tie
+// up the response for hours.
+const (
+	maxShapingDelay    = 30 * time.Second
+	maxShapingDuration = time.Minute
+)
+
+// Shaping makes a response slow, to check whether the prefetched exchanges
+// beat the network.
+type Shaping struct {
+	// FirstByteDelay delays the response after the 103 Early Hints, if any.
+	FirstByteDelay time.Duration
+	// BytesPerSecond throttles the body.
+	BytesPerSecond int
+	// StallFor stalls the body after StallAt bytes.
+	StallAt  int
+	StallFor time.Duration
+}
+
+func (sh Shaping) isZero() bool {
+	return sh == Shaping{}
+}
+
+func (sh Shaping) String() string {
+	var s []string
+	if sh.FirstByteDelay > 0 {
+		s = append(s, "delay "+sh.FirstByteDelay.String())
+	}
+	if sh.BytesPerSecond > 0 {
+		s = append(s, strconv.Itoa(sh.BytesPerSecond)+" bytes/s")
+	}
+	if sh.StallFor > 0 {
+		s = append(s, "stall "+sh.StallFor.String()+" at "+strconv.Itoa(sh.StallAt)+" bytes")
+	}
+	return strings.Join(s, ", ")
+}
+
+// The query parameters of the shaping of the response itself. The parameters
+// with the "sub_" prefix shape the alternate exchanges of the subresources,
+// and the ones with the "cert_" prefix shape the cert-chain.
+const (
+	delayParam   = "delay"
+	bpsParam     = "bps"
+	stallAtParam = "stall_at"
+	stallParam   = "stall"
+
+	subShapingPrefix  = "sub_"
+	certShapingPrefix = "cert_"
+)
+
+func shapingMillis(q url.Values, name string, d *time.Duration) {
+	if ms, err := strconv.Atoi(q.Get(name)); err == nil && ms >= 0 {
+		*d = time.Duration(ms) * time.Millisecond
+		if *d > maxShapingDelay {
+			*d = maxShapingDelay
+		}
+	}
+}
+
+func shapingInt(q url.Values, name string, n *int) {
+	if v, err := strconv.Atoi(q.Get(name)); err == nil && v >= 0 {
+		*n = v
+	}
+}
+
+// shapingFromQuery overrides sh with the query parameters with prefix.
+func shapingFromQuery(q url.Values, prefix string, sh Shaping) Shaping {
+	shapingMillis(q, prefix+delayParam, &sh.FirstByteDelay)
+	shapingInt(q, prefix+bpsParam, &sh.BytesPerSecond)
+	shapingInt(q, prefix+stallAtParam, &sh.StallAt)
+	shapingMillis(q, prefix+stallParam, &sh.StallFor)
+	return sh
+}
+
+// query returns the query parameters which shape a response with sh.
+func (sh Shaping) query() string {
+	q := url.Values{}
+	if sh.FirstByteDelay > 0 {
+		q.Set(delayParam, strconv.Itoa(int(sh.FirstByteDelay/time.Millisecond)))
+	}
+	if sh.BytesPerSecond > 0 {
+		q.Set(bpsParam, strconv.Itoa(sh.BytesPerSecond))
+	}
+	if sh.StallFor > 0 {
+		q.Set(stallAtParam, strconv.Itoa(sh.StallAt))
+		q.Set(stallParam, strconv.Itoa(int(sh.StallFor/time.Millisecond)))
+	}
+	return q.Encode()
+}
+
+func addQuery(u string, query string) string {
+	if query == "" {
+		return u
+	}
+	if strings.Contains(u, "?") {
+		return u + "&" + query
+	}
+	return u + "?" + query
+}
+
+// propagateShaping makes the outer links to the alternate exchanges and to
+// the cert-chain on r.Host, and the cert URL, point to the shaped responses.
+// The links to the alternate exchanges get the synthetic payloads of the
+// "sub_" parameters too.
+func propagateShaping(params *exchangeParams, w http.ResponseWriter, r *http.Request) {
+	subQuery := params.subShaping.query()
+	if payloadQuery := subPayloadQuery(r.URL.Query()); payloadQuery != "" {
+		if subQuery != "" {
+			subQuery += "&"
+		}
+		subQuery += payloadQuery
+	}
+	certQuery := params.certShaping.query()
+	if strings.HasPrefix(params.certUrl, "https://"+r.Host+"/") {
+		params.certUrl = addQuery(params.certUrl, certQuery)
+	}
+
+	links, err := parseLinks(w.Header()["Link"])
+	if err != nil {
+		return
+	}
+	base := &url.URL{Scheme: "https", Host: r.Host}
+	var rewritten []string
+	for _, l := range links {
+		if u, err := base.Parse(l.target); err == nil && u.Host == r.Host {
+			switch {
+			case strings.HasPrefix(u.Path, "/sxg/"):
+				l.target = addQuery(l.target, subQuery)
+			case strings.HasPrefix(u.Path, "/cert/"):
+				l.target = addQuery(l.target, certQuery)
+			}
+		}
+		rewritten = append(rewritten, l.String())
+	}
+	if len(rewritten) > 0 {
+		w.Header()["Link"] = rewritten
+	}
+}
+
+// shapedWriter writes the body of a response with a Shaping.
+type shapedWriter struct {
+	http.ResponseWriter
+	shaping Shaping
+	written int
+	// deadline is when the shaping of the body ends.
+	deadline time.Time
+}
+
+// newShapedWriter sleeps for the first byte delay, and returns the writer of
+// the rest of the response.
+func newShapedWriter(w http.ResponseWriter, sh Shaping) http.ResponseWriter {
+	if sh.isZero() {
+		return w
+	}
+	time.Sleep(sh.FirstByteDelay)
+	return &shapedWriter{ResponseWriter: w, shaping: sh, deadline: time.Now().Add(maxShapingDuration)}
+}
+
+// sleep sleeps for d, but not past the deadline.
+func (w *shapedWriter) sleep(d time.Duration) {
+	if remaining := time.Until(w.deadline); d > remaining {
+		d = remaining
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+func (w *shapedWriter) flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *shapedWriter) Write(b []byte) (int, error) {
+	total := 0
+	for len(b) > 0 {
+		if time.Now().After(w.deadline) {
+			n, err := w.ResponseWriter.Write(b)
+			w.written += n
+			return total + n, err
+		}
+		chunk := len(b)
+		if w.shaping.BytesPerSecond > 0 {
+			// Write about 10 chunks per second.
+			chunk = w.shaping.BytesPerSecond/10 + 1
+		}
+		stalls := w.shaping.StallFor > 0 && w.written < w.shaping.StallAt
+		if stalls && w.shaping.StallAt-w.written < chunk {
+			chunk = w.shaping.StallAt - w.written
+		}
+		if chunk > len(b) {
+			chunk = len(b)
+		}
+
+		n, err := w.ResponseWriter.Write(b[:chunk])
+		total += n
+		w.written += n
+		if err != nil {
+			return total, err
+		}
+		b = b[chunk:]
+
+		if stalls && w.written == w.shaping.StallAt {
+			w.flush()
+			w.sleep(w.shaping.StallFor)
+		}
+		if w.shaping.BytesPerSecond > 0 {
+			w.flush()
+			w.sleep(time.Duration(chunk) * time.Second / time.Duration(w.shaping.BytesPerSecond))
+		}
+	}
+	return total, nil
+}
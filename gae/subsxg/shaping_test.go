@@ -0,0 +1,55 @@
+package subsxg
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShapedWriterDeadline(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := &shapedWriter{
+		ResponseWriter: rec,
+		shaping:        Shaping{BytesPerSecond: 1, StallAt: 2, StallFor: maxShapingDelay},
+		deadline:       time.Now().Add(100 * time.Millisecond),
+	}
+	body := bytes.Repeat([]byte("x"), 1000)
+	start := time.Now()
+	if n, err := w.Write(body); err != nil || n != len(body) {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the shaping took %v after the deadline", elapsed)
+	}
+	if !bytes.Equal(rec.Body.Bytes(), body) {
+		t.Errorf("body of %d bytes", rec.Body.Len())
+	}
+}
+
+func TestPropagateShaping(t *testing.T) {
+	params := &exchangeParams{
+		certUrl:     "https://sxg.test/cert/cert.cbor",
+		subShaping:  Shaping{FirstByteDelay: time.Second},
+		certShaping: Shaping{FirstByteDelay: 2 * time.Second},
+	}
+	r := httptest.NewRequest("GET", "https://sxg.test/sxg/a.sxg", nil)
+	w := httptest.NewRecorder()
+	w.Header()["Link"] = []string{
+		`<https://sxg.test/sxg/b.sxg?x=1>;rel=alternate;type="application/signed-exchange;v=b3";anchor="https://demo.test/b.js", </cert/cert.cbor>; rel="preload"; as="fetch"`,
+		`<https://other.test/sxg/c.sxg>;rel="alternate"`,
+	}
+	propagateShaping(params, w, r)
+	want := []string{
+		`<https://sxg.test/sxg/b.sxg?x=1&delay=1000>;rel="alternate";type="application/signed-exchange;v=b3";anchor="https://demo.test/b.js"`,
+		`</cert/cert.cbor?delay=2000>;rel="preload";as="fetch"`,
+		`<https://other.test/sxg/c.sxg>;rel="alternate"`,
+	}
+	if got := w.Header()["Link"]; strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("links %q, want %q", got, want)
+	}
+	if want := "https://sxg.test/cert/cert.cbor?delay=2000"; params.certUrl != want {
+		t.Errorf("cert URL %s, want %s", params.certUrl, want)
+	}
+}
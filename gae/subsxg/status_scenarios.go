@@ -0,0 +1,148 @@
+package subsxg
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// The inner statuses which the browsers don't accept. Only 200 responses can
+// be served from signed exchanges.
+var nonOKInnerStatuses = []int{
+	http.StatusMovedPermanently,
+	http.StatusFound,
+	http.StatusSeeOther,
+	http.StatusTemporaryRedirect,
+	http.StatusPermanentRedirect,
+	http.StatusNotFound,
+	http.StatusInternalServerError,
+}
+
+// altHost returns the host of altDemoDomainName with the port of r, so that
+// the cross-origin hops work on the local TLS mode too.
+func (s *Server) altHost(r *http.Request) string {
+	if _, port, err := net.SplitHostPort(r.Host); err == nil {
+		return net.JoinHostPort(s.altDemoDomainName, port)
+	}
+	return s.altDemoDomainName
+}
+
+// isServedHost returns whether host, with or without a port, is the host of r
+// or the domain of an identity, which are the hosts that serve the scenarios.
+// Anything else in host, such as a userinfo, a path or a non-numeric port,
+// makes it not served, since the URLs built from it would go elsewhere.
+func (s *Server) isServedHost(host string, r *http.Request) bool {
+	if strings.Contains(host, "@") {
+		return false
+	}
+	u, err := url.Parse("https://" + host)
+	if err != nil || u.User != nil || u.Host != host || u.Path != "" || u.RawQuery != "" || u.Fragment != "" {
+		return false
+	}
+	if port := u.Port(); port != "" {
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			return false
+		}
+	} else if strings.HasSuffix(host, ":") {
+		return false
+	}
+	if host == r.Host {
+		return true
+	}
+	return u.Hostname() == s.demoDomainName || u.Hostname() == s.altDemoDomainName
+}
+
+// statusScenarios returns the tests of the inner statuses, and of the outer
+// redirects which end at signed exchanges.
+func statusScenarios() []Scenario {
+	var scenarios []Scenario
+	for _, status := range nonOKInnerStatuses {
+		status := status
+		scenarios = append(scenarios, Scenario{
+			Name:     "status_" + strconv.Itoa(status) + ".sxg",
+			Listed:   true,
+			Expected: "The browser rejects the exchange because the inner status is " + strconv.Itoa(status) + ", and falls back to the content URL.",
+			Outcome:  OutcomeFallback,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.status = status
+				if status/100 == 3 {
+					params.resHeader.Add("location", "https://"+s.demoDomainName+"/hello.html")
+				}
+				params.resHeader.Add("cache-control", "public, max-age=600")
+			},
+		})
+	}
+
+	return append(scenarios,
+		Scenario{
+			Name:     "redirect_hello.sxg",
+			Listed:   true,
+			Expected: "The browser follows the redirect, and loads hello.html from the exchange.",
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.redirectTo = "https://" + r.Host + "/sxg/hello.sxg"
+			},
+		},
+		Scenario{
+			Name:     "redirect_chain_hello.sxg",
+			Listed:   true,
+			Expected: "The browser follows the two redirects, and loads hello.html from the exchange.",
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.redirectTo = "https://" + r.Host + "/sxg/redirect_hello.sxg"
+			},
+		},
+		Scenario{
+			Name:     "redirect_cross_origin_hello.sxg",
+			Listed:   true,
+			Expected: "The browser follows the redirect to the alt origin, and loads hello.html from the exchange served there.",
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.redirectTo = "https://" + s.altHost(r) + "/sxg/hello.sxg"
+			},
+		},
+		Scenario{
+			Name:     "redirect_cross_origin_chain_hello.sxg",
+			Listed:   true,
+			Expected: "The browser follows the redirects to the alt origin and back, and loads hello.html from the exchange.",
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.redirectTo = "https://" + s.altHost(r) + "/sxg/redirect_back_hello.sxg?origin=" + r.Host
+			},
+		},
+		Scenario{
+			Name:   "redirect_back_hello.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				// Only redirect back to the hosts which serve the scenarios,
+				// so that this is not an open redirect.
+				origin := r.URL.Query().Get("origin")
+				if origin == "" || !s.isServedHost(origin, r) {
+					origin = r.Host
+				}
+				params.redirectTo = "https://" + origin + "/sxg/hello.sxg"
+			},
+		},
+		Scenario{
+			Name:     "amptestnocdn_js_redirect_preload.sxg",
+			Listed:   true,
+			Expected: "The page is served from the exchange. The browser follows the redirect of the alternate exchange of v0.js, and uses it for the script.",
+			Subresources: map[string]Outcome{
+				"redirect_v0.sxg": OutcomeSXG,
+			},
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				v0URL := "https://" + s.demoDomainName + "/amptest/js/v0.js"
+				params.outerHeader.Add("link", alternateLink("https://"+r.Host+"/sxg/redirect_v0.sxg", v0URL, sxgContentType(params.ver)).String())
+				params.resHeader.Add("link", allowedAltSXGLink(v0URL, s.childHeaderIntegrity(r, "https://"+r.Host+"/sxg/v0.sxg")).String())
+				params.resHeader.Add("link", preloadLink(v0URL, "script").String())
+				params.contentUrl = "https://" + s.demoDomainName + "/amptest/amptestnocdn.html"
+				params.payload = s.contentPayload("amptestnocdn.html")
+			},
+		},
+		Scenario{
+			Name:   "redirect_v0.sxg",
+			Listed: false,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				params.redirectTo = "https://" + r.Host + "/sxg/v0.sxg"
+			},
+		},
+	)
+}
@@ -0,0 +1,30 @@
+package subsxg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestRedirectBackOrigin(t *testing.T) {
+	s := newTestServer(t)
+	for origin, want := range map[string]string{
+		"":                                "https://" + testAltDomainName + "/sxg/hello.sxg",
+		testDomainName:                    "https://" + testDomainName + "/sxg/hello.sxg",
+		testDomainName + ":8443":          "https://" + testDomainName + ":8443/sxg/hello.sxg",
+		"evil.example":                    "https://" + testAltDomainName + "/sxg/hello.sxg",
+		"evil.example/" + testHost:        "https://" + testAltDomainName + "/sxg/hello.sxg",
+		testDomainName + ":@evil.example": "https://" + testAltDomainName + "/sxg/hello.sxg",
+		testDomainName + "@evil.example":  "https://" + testAltDomainName + "/sxg/hello.sxg",
+		testDomainName + ":https":         "https://" + testAltDomainName + "/sxg/hello.sxg",
+		testDomainName + ":":              "https://" + testAltDomainName + "/sxg/hello.sxg",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "https://"+testAltDomainName+"/sxg/redirect_back_hello.sxg?origin="+url.QueryEscape(origin), nil)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if got := rec.Header().Get("Location"); rec.Code != http.StatusFound || got != want {
+			t.Errorf("origin %q: %d %s, want %s", origin, rec.Code, got, want)
+		}
+	}
+}
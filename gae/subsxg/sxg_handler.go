@@ -0,0 +1,450 @@
+// Copyright 2018 Google Inc. All rights reserved.
+// Use of this source code is governed by the Apache 2.0
+// license that can be found in the LICENSE file.
+
+package subsxg
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+const defaultPayload = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Hello SignedHTTPExchange</title>
+  </head>
+  <body>
+    <div id="message">
+      <h1>Hello SignedHTTPExchange</h1>
+    </div>
+  </body>
+</html>
+`
+
+const nosniffablePayload = `<!doc`
+
+// Any content in the content store can be signed at
+// autoSXGPathPrefix + <path> + ".sxg".
+const autoSXGPathPrefix = "/sxg/auto/"
+
+// Appending earlyHintsSuffix to the name of any scenario (e.g.
+// hello_certpush_early_hints.sxg) serves the scenario with 103 Early Hints.
+const earlyHintsSuffix = "_early_hints.sxg"
+
+const defaultMIRecordSize = 4096
+
+type exchangeParams struct {
+	ver         version.Version
+	contentUrl  string
+	certUrl     string
+	validityUrl string
+	contentType string
+	status      int
+	resHeader   http.Header
+	payload     []byte
+	recordSize  int
+	date        time.Time
+	rand        io.Reader
+	identity    *Identity
+	earlyHints  bool
+	// outerHeader is added to the headers of the outer response, e.g. the
+	// alternate links.
+	outerHeader http.Header
+	// redirectTo makes the outer response a redirect instead of the
+	// exchange.
+	redirectTo string
+	// The shapings of the response, of the alternate exchanges and of the
+	// cert-chain.
+	shaping     Shaping
+	subShaping  Shaping
+	certShaping Shaping
+	// permissiveHeaders lets the inner response headers which break the
+	// signing rules through, for the negative tests.
+	permissiveHeaders bool
+	// headerViolations are set by createExchange.
+	headerViolations []headerViolation
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// buildExchange builds the unsigned exchange of params, with the content-type
+// and content-length headers. params is not modified.
+func buildExchange(params *exchangeParams) (*signedexchange.Exchange, []headerViolation, error) {
+	reqHeader := http.Header{}
+	resHeader := params.resHeader.Clone()
+	resHeader.Add("content-type", params.contentType)
+	resHeader.Add("content-length", strconv.Itoa(len(params.payload)))
+
+	resHeader, violations := sanitizeHeaders(resHeader)
+	if len(violations) > 0 && !params.permissiveHeaders {
+		return nil, violations, &headerRuleError{violations: violations}
+	}
+
+	e := signedexchange.NewExchange(params.ver, params.contentUrl, http.MethodGet, reqHeader, params.status, resHeader, []byte(params.payload))
+	if err := e.MiEncodePayload(params.recordSize); err != nil {
+		return nil, violations, err
+	}
+	return e, violations, nil
+}
+
+func createExchange(params *exchangeParams) (*signedexchange.Exchange, error) {
+	certUrl, _ := url.Parse(params.certUrl)
+	validityUrl, _ := url.Parse(params.validityUrl)
+
+	e, violations, err := buildExchange(params)
+	params.headerViolations = violations
+	if err != nil {
+		return nil, err
+	}
+
+	s := &signedexchange.Signer{
+		Date:        params.date,
+		Expires:     params.date.Add(time.Hour * 24),
+		Certs:       params.identity.certs,
+		CertUrl:     certUrl,
+		ValidityUrl: validityUrl,
+		PrivKey:     params.identity.prvKey,
+		Rand:        params.rand,
+	}
+	if s == nil {
+		return nil, errors.New("Failed to sign")
+	}
+	if err := e.AddSignatureHeader(s); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// exchangeHeaderIntegrity returns the header-integrity of the exchange of
+// params without signing it. params is not modified.
+func exchangeHeaderIntegrity(params *exchangeParams) (string, error) {
+	e, _, err := buildExchange(params)
+	if err != nil {
+		return "", err
+	}
+	return headerIntegrity(e)
+}
+
+// headerIntegrity returns the header-integrity of the allowed-alt-sxg links
+// to e: the SHA-256 of its signed headers.
+func headerIntegrity(e *signedexchange.Exchange) (string, error) {
+	var headerBuf bytes.Buffer
+	if err := e.DumpExchangeHeaders(&headerBuf); err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(headerBuf.Bytes())
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:]), nil
+}
+
+// The header-integrity which is used for an alternate exchange that links
+// back to one of its parents. It can't match, since the exchange would depend
+// on its own header-integrity.
+var cyclicHeaderIntegrity = func() string {
+	sum := sha256.Sum256(nil)
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}()
+
+// integrityChainKey is the context key of the /sxg/ paths whose
+// header-integrity is being derived, to detect the cycles.
+type integrityChainKey struct{}
+
+// integrityChainPath returns the path which identifies the exchange of u in
+// the chain. The queries, such as the shapings, and the early hints don't make
+// another exchange in the cycle, so that a parent which is requested with them
+// breaks the cycle at the same link as without them.
+func integrityChainPath(u *url.URL) string {
+	if strings.HasSuffix(u.Path, earlyHintsSuffix) {
+		return strings.TrimSuffix(u.Path, earlyHintsSuffix) + ".sxg"
+	}
+	return u.Path
+}
+
+// childHeaderIntegrity returns the header-integrity of the exchange which is
+// served at sxgURL, derived from the very same exchangeParams which the
+// handler of sxgURL signs. modify is applied to the params first, which lets
+// the _error scenarios break the value. An empty string is returned when the
+// exchange can't be built.
+func (s *Server) childHeaderIntegrity(r *http.Request, sxgURL string, modify ...func(params *exchangeParams)) string {
+	u, err := url.Parse(sxgURL)
+	if err != nil {
+		log.Printf("Failed to derive the header-integrity of %s: %v", sxgURL, err)
+		return ""
+	}
+	// The alternate exchanges get the synthetic payloads of the "sub_"
+	// parameters through the outer links, as propagateShaping does.
+	if query := subPayloadQuery(r.URL.Query()); query != "" && u.Host == r.Host {
+		if u, err = url.Parse(addQuery(sxgURL, query)); err != nil {
+			log.Printf("Failed to derive the header-integrity of %s: %v", sxgURL, err)
+			return ""
+		}
+	}
+	chain, _ := r.Context().Value(integrityChainKey{}).([]string)
+	if len(chain) == 0 {
+		chain = []string{integrityChainPath(r.URL)}
+	}
+	for _, c := range chain {
+		if c == integrityChainPath(u) {
+			return cyclicHeaderIntegrity
+		}
+	}
+	chain = append(chain[:len(chain):len(chain)], integrityChainPath(u))
+
+	child := r.Clone(context.WithValue(r.Context(), integrityChainKey{}, chain))
+	child.URL = u
+	child.Host = u.Host
+	child.RequestURI = ""
+	child.Header.Set("Accept", sxgContentType(version.Version1b3))
+	params, err := s.newExchangeParams(child)
+	if err != nil {
+		log.Printf("Failed to derive the header-integrity of %s: %v", sxgURL, err)
+		return ""
+	}
+	if params.redirectTo != "" {
+		log.Printf("Failed to derive the header-integrity of %s: redirected to %s", sxgURL, params.redirectTo)
+		return ""
+	}
+	for _, m := range modify {
+		m(params)
+	}
+	integrity, err := exchangeHeaderIntegrity(params)
+	if err != nil {
+		log.Printf("Failed to derive the header-integrity of %s: %v", sxgURL, err)
+		return ""
+	}
+	return integrity
+}
+
+// serveExchange responds with the signed exchange built from params if the
+// client accepts it. Otherwise it falls back to what a publisher serves to
+// non-SXG clients: a redirect to the content URL when the "fallback=redirect"
+// query parameter is set, or the unsigned payload.
+func (s *Server) serveExchange(params *exchangeParams, w http.ResponseWriter, r *http.Request) {
+	propagateShaping(params, w, r)
+	if !acceptsSignedExchange(r.Header, params.ver) {
+		w.Header().Add("Vary", "Accept")
+		s.serveFallback(params, newShapedWriter(w, params.shaping), r)
+		return
+	}
+
+	if params.earlyHints {
+		writeEarlyHints(w)
+	}
+	w.Header().Add("Vary", "Accept")
+	w = newShapedWriter(w, params.shaping)
+
+	start := time.Now()
+	e, err := createExchange(params)
+	signingTime := time.Since(start)
+	if err != nil {
+		params.identity.recordSigningError(err, s.now())
+		s.logSigning(params, r.URL.Path, "error", signingTime, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.logSigning(params, r.URL.Path, "signed", signingTime, nil)
+
+	w.Header().Set("Content-Type", sxgContentType(params.ver))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	e.Write(w)
+}
+
+// writeEarlyHints sends the outer link headers which have been added to w so
+// far as a 103 Early Hints response, so that the browser can start loading the
+// alternate subresources and the certificate before signing finishes.
+func writeEarlyHints(w http.ResponseWriter) {
+	h := w.Header()
+	if len(h["Link"]) == 0 {
+		return
+	}
+	saved := h.Clone()
+	for name := range h {
+		if name != "Link" {
+			delete(h, name)
+		}
+	}
+	w.WriteHeader(http.StatusEarlyHints)
+	for name, values := range saved {
+		h[name] = values
+	}
+}
+
+func (s *Server) serveFallback(params *exchangeParams, w http.ResponseWriter, r *http.Request) {
+	// The outer link headers only make sense for the signed exchange.
+	w.Header().Del("Link")
+
+	if r.URL.Query().Get("fallback") == "redirect" {
+		s.logSigning(params, r.URL.Path, "redirect", 0, nil)
+		http.Redirect(w, r, params.contentUrl, http.StatusFound)
+		return
+	}
+	s.logSigning(params, r.URL.Path, "unsigned", 0, nil)
+
+	for name, values := range params.resHeader {
+		w.Header()[name] = values
+	}
+	// Nor do the inner links which load the alternate exchanges.
+	w.Header().Del("Link")
+	if links, err := parseLinks(params.resHeader["Link"]); err == nil {
+		for _, l := range links {
+			if !l.hasRel("allowed-alt-sxg") && !l.hasRel("preload") {
+				w.Header().Add("Link", l.String())
+			}
+		}
+	}
+	w.Header().Set("Content-Type", params.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(params.payload)))
+	w.WriteHeader(params.status)
+	w.Write(params.payload)
+}
+
+// setupAutoExchange sets up params to sign the content in the content store
+// for path. The content URL is the URL at which the origin of demoDomainName
+// serves the content, or the origin of altDemoDomainName with the
+// "identity=alt" query parameter. The subresources of HTML contents are
+// discovered and linked unless the "discover=0" query parameter is set.
+func (s *Server) setupAutoExchange(params *exchangeParams, path string, r *http.Request) error {
+	c, ok := s.contents.Get(strings.TrimSuffix(strings.TrimPrefix(path, autoSXGPathPrefix), ".sxg"))
+	if !ok || !strings.HasSuffix(path, ".sxg") {
+		return &exchangeError{status: http.StatusNotFound, msg: "setupAutoExchange"}
+	}
+
+	domainName := s.demoDomainName
+	if r.URL.Query().Get("identity") == "alt" {
+		domainName = s.altDemoDomainName
+		params.identity = s.altIdentity
+		params.certUrl = "https://" + r.Host + s.altIdentity.certURLPath
+		params.validityUrl = "https://" + s.altDemoDomainName + "/cert/null.validity.msg"
+	}
+	params.contentUrl = "https://" + domainName + s.originPath(domainName, c.path)
+	params.contentType = c.contentType
+	params.payload = c.payload
+	params.resHeader.Add("cache-control", "public, max-age=600")
+	params.outerHeader.Add("cache-control", "public, max-age=600")
+	if strings.HasPrefix(c.contentType, "text/html") && r.URL.Query().Get("discover") != "0" {
+		s.addDiscoveredSubresourceLinks(params, r)
+	}
+	return nil
+}
+
+// An exchangeError is the error response of an /sxg/ URL which can't be
+// signed.
+type exchangeError struct {
+	status int
+	msg    string
+}
+
+func (e *exchangeError) Error() string {
+	return e.msg
+}
+
+func (s *Server) signedExchangeHandler(w http.ResponseWriter, r *http.Request) {
+	s.addReportingHeaders(w, r)
+	params, err := s.newExchangeParams(r)
+	if err != nil {
+		var xerr *exchangeError
+		if !errors.As(err, &xerr) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		http.Error(w, xerr.msg, xerr.status)
+		return
+	}
+	for name, values := range params.outerHeader {
+		w.Header()[name] = append(w.Header()[name], values...)
+	}
+	if params.redirectTo != "" {
+		http.Redirect(w, r, params.redirectTo, http.StatusFound)
+		return
+	}
+	s.serveExchange(params, w, r)
+}
+
+// newExchangeParams returns the params of the exchange at the /sxg/ URL of r.
+// The outer response is a redirect instead when params.redirectTo is set.
+func (s *Server) newExchangeParams(r *http.Request) (*exchangeParams, error) {
+	params := &exchangeParams{
+		ver:         version.Version1b3,
+		contentUrl:  "https://" + s.demoDomainName + "/hello.html",
+		certUrl:     "https://" + r.Host + s.defaultIdentity.certURLPath,
+		validityUrl: "https://" + s.demoDomainName + "/cert/null.validity.msg",
+		contentType: "text/html; charset=utf-8",
+		status:      http.StatusOK,
+		resHeader:   http.Header{},
+		outerHeader: http.Header{},
+		payload:     []byte(defaultPayload),
+		recordSize:  defaultMIRecordSize,
+		date:        s.now().Add(-time.Second * 10),
+		rand:        s.rand,
+		identity:    s.defaultIdentity,
+		earlyHints:  r.URL.Query().Get("early_hints") == "1",
+
+		permissiveHeaders: r.URL.Query().Get("headers") == "permissive",
+	}
+
+	q := r.URL.Query()
+	params.shaping = shapingFromQuery(q, "", Shaping{})
+	params.subShaping = shapingFromQuery(q, subShapingPrefix, Shaping{})
+	params.certShaping = shapingFromQuery(q, certShapingPrefix, Shaping{})
+
+	path := r.URL.Path
+	if strings.HasSuffix(path, earlyHintsSuffix) {
+		path = strings.TrimSuffix(path, earlyHintsSuffix) + ".sxg"
+		params.earlyHints = true
+	}
+
+	switch {
+	case strings.HasPrefix(path, autoSXGPathPrefix):
+		if err := s.setupAutoExchange(params, path, r); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(path, playgroundSXGPathPrefix):
+		if err := s.setupPlaygroundExchange(params, path, r); err != nil {
+			return nil, err
+		}
+	default:
+		scenario, ok := s.scenarioNames[strings.TrimPrefix(path, "/sxg/")]
+		if !ok {
+			return nil, &exchangeError{status: http.StatusNotFound, msg: "signedExchangeHandler"}
+		}
+		params.shaping = shapingFromQuery(q, "", scenario.Shaping)
+		params.subShaping = shapingFromQuery(q, subShapingPrefix, scenario.SubresourceShaping)
+		params.certShaping = shapingFromQuery(q, certShapingPrefix, scenario.CertShaping)
+		scenario.setup(s, params, r)
+		if params.redirectTo != "" {
+			return params, nil
+		}
+	}
+	// Any exchange can be signed with another inner status and a synthetic
+	// payload, both as a parent and as a subresource.
+	if status, err := strconv.Atoi(q.Get("status")); err == nil && status >= 100 && status <= 599 {
+		params.status = status
+	}
+	if err := applySyntheticPayload(params, q); err != nil {
+		return nil, &exchangeError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+	if rewrite, ok := r.Context().Value(exchangeRewriterKey{}).(exchangeRewriter); ok {
+		rewrite(params, params.outerHeader)
+	}
+	return params, nil
+}
@@ -0,0 +1,193 @@
+package subsxg
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+func TestExchangeHeaderIntegrityDoesNotModifyParams(t *testing.T) {
+	params := &exchangeParams{
+		ver:         version.Version1b3,
+		contentUrl:  "https://" + testDomainName + "/hello.html",
+		contentType: "text/html",
+		status:      http.StatusOK,
+		resHeader:   http.Header{"cache-control": {"max-age=60"}},
+		payload:     []byte(defaultPayload),
+		recordSize:  defaultMIRecordSize,
+	}
+	want := params.resHeader.Clone()
+	first, err := exchangeHeaderIntegrity(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := exchangeHeaderIntegrity(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("header-integrity changed from %s to %s", first, second)
+	}
+	if !reflect.DeepEqual(params.resHeader, want) {
+		t.Errorf("headers are modified to %v", params.resHeader)
+	}
+}
+
+// The header-integrity follows the definition of the child exchange, whatever
+// its headers, status and record size are.
+func TestChildHeaderIntegrity(t *testing.T) {
+	child := Scenario{
+		Name: "child.sxg",
+		setup: func(s *Server, params *exchangeParams, r *http.Request) {
+			params.contentUrl = "https://" + s.demoDomainName + "/child.js"
+			params.contentType = "text/javascript"
+			params.payload = []byte("console.log('child');")
+			params.status = http.StatusNotFound
+			params.recordSize = 16
+			params.resHeader.Add("cache-control", "max-age=1")
+			params.resHeader.Add("x-child", "1")
+		},
+	}
+	parent := Scenario{
+		Name: "parent.sxg",
+		setup: func(s *Server, params *exchangeParams, r *http.Request) {
+			childURL := "https://" + s.demoDomainName + "/child.js"
+			params.outerHeader.Add("link", alternateLink("https://"+r.Host+"/sxg/child.sxg", childURL, sxgContentType(params.ver)).String())
+			params.resHeader.Add("link", allowedAltSXGLink(childURL, s.childHeaderIntegrity(r, "https://"+r.Host+"/sxg/child.sxg")).String())
+			params.resHeader.Add("link", preloadLink(childURL, "script").String())
+		},
+	}
+	s := newTestServer(t, WithScenarios(parent, child))
+	rec := get(t, s, "https://"+testHost+"/sxg/parent.sxg", testAccept)
+	e := readExchange(t, rec)
+	checkAlternates(t, s, "parent.sxg", rec.Header()["Link"], e.ResponseHeaders["Link"])
+}
+
+// The clients which don't accept signed exchanges get what the publishers
+// serve them: the unsigned content, or a redirect to it.
+func TestServeFallback(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...))
+	name := "amptestnocdn_js_img_preload.sxg"
+	signed := readExchange(t, get(t, s, "https://"+testHost+"/sxg/"+name, testAccept))
+
+	for _, accept := range []string{"text/html,*/*;q=0.8", "application/signed-exchange;v=b3;q=0"} {
+		req := httptest.NewRequest(http.MethodGet, "https://"+testHost+"/sxg/"+name, nil)
+		req.Header.Set("Accept", accept)
+		rec := httptest.NewRecorder()
+		s.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", accept, rec.Code)
+		}
+		if vary := rec.Header().Get("Vary"); vary != "Accept" {
+			t.Errorf("%s: Vary is %q", accept, vary)
+		}
+		if ct := rec.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+			t.Errorf("%s: Content-Type is %q", accept, ct)
+		}
+		if want := s.contentPayload("amptestnocdn.html"); !bytes.Equal(rec.Body.Bytes(), want) {
+			t.Errorf("%s: the body is not the unsigned content", accept)
+		}
+		links, err := parseLinks(rec.Header()["Link"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range links {
+			if l.hasRel("alternate") || l.hasRel("allowed-alt-sxg") || l.hasRel("preload") {
+				t.Errorf("%s: the unsigned response has the link %s", accept, l)
+			}
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "https://"+testHost+"/sxg/"+name+"?fallback=redirect", nil)
+	req.Header.Set("Accept", "text/html")
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != signed.RequestURI {
+		t.Errorf("fallback=redirect: %d to %q, want %d to %s", rec.Code, rec.Header().Get("Location"), http.StatusFound, signed.RequestURI)
+	}
+	if vary := rec.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("fallback=redirect: Vary is %q", vary)
+	}
+}
+
+// The cycles are broken at the same link whatever the query of the parent is.
+func TestChildHeaderIntegrityCycleIgnoresQuery(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...))
+	var want []string
+	for _, p := range []string{"/sxg/a_css.sxg", "/sxg/a_css.sxg?delay=0", "/sxg/a_css.sxg?status=200"} {
+		e := readExchange(t, get(t, s, "https://"+testHost+p, testAccept))
+		got := e.ResponseHeaders["Link"]
+		if want == nil {
+			want = got
+			continue
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: links %q, want %q", p, got, want)
+		}
+	}
+	u, _ := url.Parse("https://" + testHost + "/sxg/a_css_early_hints.sxg?delay=0")
+	if got := integrityChainPath(u); got != "/sxg/a_css.sxg" {
+		t.Errorf("integrityChainPath(%s) = %s", u, got)
+	}
+}
+
+// The 103 Early Hints response carries only the outer links, and the final
+// response keeps the rest of the headers. httptest.ResponseRecorder doesn't
+// record the 1xx responses, so this runs a real server.
+func TestEarlyHints(t *testing.T) {
+	s := newTestServer(t, WithScenarios(unshapedScenarios()...), WithReporting(true))
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var hints []textproto.MIMEHeader
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			if code == http.StatusEarlyHints {
+				hints = append(hints, header)
+			}
+			return nil
+		},
+	}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(context.Background(), trace), http.MethodGet, ts.URL+"/sxg/amptestnocdn_js_img_preload_early_hints.sxg", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Accept", testAccept)
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status %d", res.StatusCode)
+	}
+	if len(hints) != 1 {
+		t.Fatalf("%d early hints", len(hints))
+	}
+	for name := range hints[0] {
+		if name != "Link" {
+			t.Errorf("the early hints have %s", name)
+		}
+	}
+	if len(hints[0]["Link"]) == 0 || !reflect.DeepEqual(hints[0]["Link"], res.Header["Link"]) {
+		t.Errorf("early hints links %q, final links %q", hints[0]["Link"], res.Header["Link"])
+	}
+	for _, name := range []string{"Report-To", "Nel", "Vary", "Content-Type", "X-Content-Type-Options"} {
+		if res.Header.Get(name) == "" {
+			t.Errorf("the final response has no %s", name)
+		}
+	}
+}
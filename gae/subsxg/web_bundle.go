@@ -0,0 +1,414 @@
+package subsxg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/WICG/webpackage/go/bundle"
+	"github.com/WICG/webpackage/go/signedexchange"
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+// BundleOptions are the options of the scenarios converted from a Web Bundle.
+type BundleOptions struct {
+	// Name is the name of the parent scenario. It defaults to the host of
+	// the first exchange, such as bundle_www_example_com.sxg.
+	Name string
+}
+
+type bundleImport struct {
+	bundle *bundle.Bundle
+	opts   BundleOptions
+}
+
+// WithBundle adds a scenario for each exchange in b, and a parent scenario
+// which has the alternate, allowed-alt-sxg and preload links to them. The
+// parent is the first HTML document in b, or the default hello.html
+// exchange if b has none.
+func WithBundle(b *bundle.Bundle, opts BundleOptions) Option {
+	return func(s *Server) { s.bundles = append(s.bundles, bundleImport{bundle: b, opts: opts}) }
+}
+
+// The host of the /sxg/ URLs which PackBundle fetches in process.
+const bundlePackHost = "sub-sxg.invalid"
+
+// bundleVariants returns the response headers of the exchanges in b. The
+// responses which share a URL without the Variants-04 header get the
+// Variants-04 and Variant-Key-04 headers from the request headers which
+// differ among them.
+func bundleVariants(b *bundle.Bundle) []http.Header {
+	headers := make([]http.Header, len(b.Exchanges))
+	byURL := map[string][]int{}
+	for i, e := range b.Exchanges {
+		headers[i] = e.Response.Header.Clone()
+		if headers[i] == nil {
+			headers[i] = http.Header{}
+		}
+		byURL[e.Request.URL.String()] = append(byURL[e.Request.URL.String()], i)
+	}
+	for _, indices := range byURL {
+		if len(indices) < 2 || headers[indices[0]].Get(variantsParam) != "" {
+			continue
+		}
+		var names []string
+		for name := range b.Exchanges[indices[0]].Request.Header {
+			names = append(names, name)
+		}
+		for _, i := range indices[1:] {
+			for name := range b.Exchanges[i].Request.Header {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		var axes []string
+		var differing []string
+		for j, name := range names {
+			if j > 0 && names[j-1] == name {
+				continue
+			}
+			seen := map[string]bool{}
+			values := []string{name}
+			for _, i := range indices {
+				v := b.Exchanges[i].Request.Header.Get(name)
+				if !seen[v] {
+					seen[v] = true
+					values = append(values, v)
+				}
+			}
+			if len(seen) > 1 {
+				axes = append(axes, strings.ToLower(name)+";"+strings.Join(values[1:], ";"))
+				differing = append(differing, name)
+			}
+		}
+		if len(axes) == 0 {
+			continue
+		}
+		for _, i := range indices {
+			var key []string
+			for _, name := range differing {
+				key = append(key, b.Exchanges[i].Request.Header.Get(name))
+			}
+			headers[i].Set(variantsParam, strings.Join(axes, ", "))
+			headers[i].Set(variantKeyParam, strings.Join(key, ";"))
+		}
+	}
+	return headers
+}
+
+// variantLinkParams returns the variants-04 and variant-key-04 parameters of
+// the links to an exchange with the response headers h.
+func variantLinkParams(h http.Header) []linkParam {
+	var params []linkParam
+	for _, name := range []string{variantsParam, variantKeyParam} {
+		if v := h.Get(name); v != "" {
+			params = append(params, linkParam{name: name, value: v})
+		}
+	}
+	return params
+}
+
+// preloadAs returns the "as" of the preload links for contentType, and
+// whether they are fetched in CORS mode.
+func preloadAs(contentType string) (string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/css":
+		return "style", false
+	case strings.Contains(mediaType, "javascript"):
+		return "script", false
+	case strings.HasPrefix(mediaType, "image/"):
+		return "image", false
+	case strings.HasPrefix(mediaType, "font/"):
+		return "font", true
+	case mediaType == "text/html":
+		return "document", false
+	}
+	return "fetch", true
+}
+
+// setupBundleExchange sets params from the exchange of the bundle. The
+// exchanges on the alt demo domain are signed by the alt identity, and the
+// others by the default identity.
+func setupBundleExchange(s *Server, params *exchangeParams, r *http.Request, e *bundle.Exchange, header http.Header) {
+	if e.Request.URL.Host == s.altDemoDomainName {
+		params.identity = s.altIdentity
+		params.certUrl = "https://" + r.Host + s.altIdentity.certURLPath
+		params.validityUrl = "https://" + s.altDemoDomainName + "/cert/null.validity.msg"
+	}
+	params.contentUrl = e.Request.URL.String()
+	params.status = e.Response.Status
+	params.payload = e.Response.Body
+	params.resHeader = header.Clone()
+	params.contentType = params.resHeader.Get("Content-Type")
+	if params.contentType == "" {
+		params.contentType = http.DetectContentType(params.payload)
+	}
+	params.resHeader.Del("Content-Type")
+	params.resHeader.Del("Content-Length")
+}
+
+// importBundle adds the scenarios of a bundle. The exchange i other than the
+// parent is <name>_<i>.sxg.
+func (s *Server) importBundle(im bundleImport) error {
+	b := im.bundle
+	if len(b.Exchanges) == 0 {
+		return errors.New("the bundle has no exchanges")
+	}
+	name := im.opts.Name
+	if name == "" {
+		name = "bundle_" + strings.NewReplacer(".", "_", "-", "_", ":", "_").Replace(b.Exchanges[0].Request.URL.Host) + ".sxg"
+	}
+	if !strings.HasSuffix(name, ".sxg") {
+		name += ".sxg"
+	}
+	for _, sc := range s.scenarios {
+		if sc.Name == name {
+			return fmt.Errorf("the scenario %s already exists", name)
+		}
+	}
+
+	headers := bundleVariants(b)
+	main := -1
+	for i, e := range b.Exchanges {
+		if mediaType, _, _ := mime.ParseMediaType(headers[i].Get("Content-Type")); main < 0 && e.Response.Status == http.StatusOK && mediaType == "text/html" {
+			main = i
+		}
+	}
+
+	type child struct {
+		name     string
+		exchange *bundle.Exchange
+		header   http.Header
+	}
+	var children []child
+	subresources := map[string]Outcome{}
+	for i, e := range b.Exchanges {
+		if i == main {
+			continue
+		}
+		e, header := e, headers[i]
+		c := child{name: fmt.Sprintf("%s_%d.sxg", strings.TrimSuffix(name, ".sxg"), i), exchange: e, header: header}
+		s.scenarios = append(s.scenarios, Scenario{
+			Name: c.name,
+			setup: func(s *Server, params *exchangeParams, r *http.Request) {
+				setupBundleExchange(s, params, r, e, header)
+			},
+		})
+		children = append(children, c)
+		subresources[c.name] = OutcomeSXG
+		if e.Response.Status != http.StatusOK {
+			subresources[c.name] = OutcomeFallback
+		}
+	}
+
+	s.scenarios = append(s.scenarios, Scenario{
+		Name:         name,
+		Listed:       true,
+		Subresources: subresources,
+		setup: func(s *Server, params *exchangeParams, r *http.Request) {
+			if main >= 0 {
+				setupBundleExchange(s, params, r, b.Exchanges[main], headers[main])
+			}
+			// The preload links which the main exchange has, such as the
+			// ones of a packed scenario, are not added again.
+			preloaded := map[string]bool{}
+			if links, err := parseLinks(params.resHeader["Link"]); err == nil {
+				for _, l := range links {
+					if l.hasRel("preload") {
+						preloaded[l.target] = true
+					}
+				}
+			}
+			for _, c := range children {
+				sxgURL := "https://" + r.Host + "/sxg/" + c.name
+				anchor := c.exchange.Request.URL.String()
+				variants := variantLinkParams(c.header)
+				params.outerHeader.Add("link", alternateLink(sxgURL, anchor, sxgContentType(params.ver), variants...).String())
+				params.resHeader.Add("link", allowedAltSXGLink(anchor, s.childHeaderIntegrity(r, sxgURL), variants...).String())
+				if preloaded[anchor] {
+					continue
+				}
+				preloaded[anchor] = true
+				as, crossorigin := preloadAs(c.header.Get("Content-Type"))
+				preload := preloadLink(anchor, as)
+				if crossorigin {
+					preload.params = append(preload.params, linkParam{name: "crossorigin", bare: true})
+				}
+				params.resHeader.Add("link", preload.String())
+			}
+		},
+	})
+	return nil
+}
+
+// walkExchanges fetches the exchange of the scenario name from host, and the
+// exchanges of its alternate links recursively, and calls f for each of them
+// with its outer alternate link, which is nil for the scenario itself.
+func (s *Server) walkExchanges(host string, name string, f func(name string, rec *httptest.ResponseRecorder, alternate *link) error) error {
+	visited := map[string]bool{}
+	var walk func(u *url.URL, alternate *link) error
+	walk = func(u *url.URL, alternate *link) error {
+		if visited[u.String()] {
+			return nil
+		}
+		visited[u.String()] = true
+		rec, err := s.fetchFromPublisher(u, sxgContentType(version.Version1b3))
+		if err != nil {
+			return err
+		}
+		if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), signedExchangeMIMEType) {
+			return fmt.Errorf("%s responded with %d %s", u, rec.Code, rec.Header().Get("Content-Type"))
+		}
+		if err := f(strings.TrimPrefix(u.Path, "/sxg/"), rec, alternate); err != nil {
+			return err
+		}
+		links, err := parseLinks(rec.Header()["Link"])
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if !l.hasRel("alternate") || !strings.HasPrefix(l.get("type"), signedExchangeMIMEType) {
+				continue
+			}
+			au, err := u.Parse(l.target)
+			if err != nil {
+				return err
+			}
+			if au.Host != host || !strings.HasPrefix(au.Path, "/sxg/") {
+				continue
+			}
+			if err := walk(au, l); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(&url.URL{Scheme: "https", Host: host, Path: "/sxg/" + name}, nil)
+}
+
+// WriteScenarioExchanges writes the exchange of the scenario name and the
+// exchanges of its alternate links to dir, as they are served from host,
+// along with .headers files of their outer response headers. It returns the
+// names of the files.
+func (s *Server) WriteScenarioExchanges(dir string, host string, name string) ([]string, error) {
+	var files []string
+	err := s.walkExchanges(host, name, func(name string, rec *httptest.ResponseRecorder, alternate *link) error {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		files = append(files, file)
+		return writeWPTFile(file, rec.Body.Bytes(), rec.Header())
+	})
+	return files, err
+}
+
+// variantRequestHeader returns the request header which selects the variant
+// of the Variants-04 and Variant-Key-04 values.
+func variantRequestHeader(variants string, variantKey string) http.Header {
+	header := http.Header{}
+	if variants == "" || variantKey == "" {
+		return header
+	}
+	key := strings.Split(strings.Split(variantKey, ",")[0], ";")
+	for i, axis := range strings.Split(variants, ",") {
+		name := strings.TrimSpace(strings.Split(axis, ";")[0])
+		if i < len(key) && name != "" {
+			header.Set(name, strings.TrimSpace(key[i]))
+		}
+	}
+	return header
+}
+
+// dropAllowedAltSXGLinks removes the allowed-alt-sxg links from h, since the
+// bundles don't have the signatures which they allow, and returns them.
+func dropAllowedAltSXGLinks(h http.Header) ([]string, error) {
+	links, err := parseLinks(h["Link"])
+	if err != nil {
+		return nil, err
+	}
+	var dropped []string
+	h.Del("Link")
+	for _, l := range links {
+		if l.hasRel("allowed-alt-sxg") {
+			dropped = append(dropped, l.String())
+			continue
+		}
+		h.Add("Link", l.String())
+	}
+	return dropped, nil
+}
+
+// A DroppedLink is an allowed-alt-sxg link which PackBundle doesn't keep in
+// the response headers of URL.
+type DroppedLink struct {
+	URL  string
+	Link string
+}
+
+// PackBundle packs the exchange of the scenario name and the exchanges of its
+// alternate links into a Web Bundle, keeping their URLs and response headers,
+// with the payloads decoded. The variants get the Variants-04 and
+// Variant-Key-04 headers of their alternate links, and the request headers
+// which select them.
+//
+// The allowed-alt-sxg links are not kept, so the Link headers in the bundle
+// differ from the signed ones, and the dropped links are returned. The bundles
+// have no signatures, so the header-integrity values of the links can't be
+// met. The parent scenario which unpacking the bundle generates links its
+// children again.
+func (s *Server) PackBundle(name string) (*bundle.Bundle, []DroppedLink, error) {
+	b := &bundle.Bundle{}
+	var dropped []DroppedLink
+	err := s.walkExchanges(bundlePackHost, name, func(name string, rec *httptest.ResponseRecorder, alternate *link) error {
+		e, err := signedexchange.ReadExchange(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		var verifyLog bytes.Buffer
+		payload, ok := e.Verify(s.now(), s.fetchCertForVerification, log.New(&verifyLog, "", 0))
+		if !ok {
+			return fmt.Errorf("%s is not verified: %s", name, strings.TrimSpace(verifyLog.String()))
+		}
+		u, err := url.Parse(e.RequestURI)
+		if err != nil {
+			return err
+		}
+		header := e.ResponseHeaders.Clone()
+		if strings.HasPrefix(header.Get("Content-Encoding"), "mi-sha256") {
+			header.Del("Content-Encoding")
+			header.Del("Digest")
+		}
+		header.Del("Content-Length")
+		links, err := dropAllowedAltSXGLinks(header)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		for _, l := range links {
+			dropped = append(dropped, DroppedLink{URL: e.RequestURI, Link: l})
+		}
+		if alternate != nil && header.Get(variantsParam) == "" {
+			for _, p := range variantLinkParams(http.Header{
+				variantsParam:   {alternate.get(variantsParam)},
+				variantKeyParam: {alternate.get(variantKeyParam)},
+			}) {
+				header.Set(p.name, p.value)
+			}
+		}
+		b.Exchanges = append(b.Exchanges, &bundle.Exchange{
+			Request:  bundle.Request{URL: u, Header: variantRequestHeader(header.Get(variantsParam), header.Get(variantKeyParam))},
+			Response: bundle.Response{Status: e.ResponseStatus, Header: header, Body: payload},
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, dropped, nil
+}
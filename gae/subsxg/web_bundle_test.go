@@ -0,0 +1,165 @@
+package subsxg
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WICG/webpackage/go/bundle"
+)
+
+func testBundleExchange(t *testing.T, rawURL string, reqHeader http.Header, contentType string, body string) *bundle.Exchange {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reqHeader == nil {
+		reqHeader = http.Header{}
+	}
+	return &bundle.Exchange{
+		Request: bundle.Request{URL: u, Header: reqHeader},
+		Response: bundle.Response{
+			Status: http.StatusOK,
+			Header: http.Header{"Content-Type": {contentType}, "Cache-Control": {"public, max-age=600"}},
+			Body:   []byte(body),
+		},
+	}
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	in := &bundle.Bundle{Exchanges: []*bundle.Exchange{
+		testBundleExchange(t, "https://"+testDomainName+"/b/script.js", nil, "text/javascript", "run();"),
+		testBundleExchange(t, "https://"+testDomainName+"/b/index.html", nil, "text/html; charset=utf-8", "<script src=script.js></script>"),
+		testBundleExchange(t, "https://"+testDomainName+"/b/img", http.Header{"Accept": {"image/jpeg"}}, "image/jpeg", "\xff\xd8\xff"),
+		testBundleExchange(t, "https://"+testDomainName+"/b/img", http.Header{"Accept": {"image/webp"}}, "image/webp", "RIFF"),
+		testBundleExchange(t, "https://"+testAltDomainName+"/b/style.css", nil, "text/css", "body {}"),
+	}}
+	var wbn bytes.Buffer
+	if _, err := in.WriteTo(&wbn); err != nil {
+		t.Fatal(err)
+	}
+	in, err := bundle.Read(&wbn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestServer(t, WithScenarios(), WithBundle(in, BundleOptions{Name: "b.sxg"}))
+	rec := get(t, s, "https://"+testHost+"/sxg/b.sxg", testAccept)
+	e := readExchange(t, rec)
+	if e.RequestURI != "https://"+testDomainName+"/b/index.html" {
+		t.Errorf("the parent is %s", e.RequestURI)
+	}
+	if problems := lintLinks(rec.Header()["Link"], e.ResponseHeaders["Link"]); len(problems) > 0 {
+		t.Errorf("lint: %s", strings.Join(problems, "; "))
+	}
+	checkAlternates(t, s, "b.sxg", rec.Header()["Link"], e.ResponseHeaders["Link"])
+
+	files, err := s.WriteScenarioExchanges(t.TempDir(), testHost, "b.sxg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(in.Exchanges) {
+		t.Errorf("files %q", files)
+	}
+	for _, file := range files {
+		if !strings.HasPrefix(filepath.Base(file), "b") {
+			t.Errorf("unexpected file %s", file)
+		}
+	}
+
+	out, dropped, err := s.PackBundle("b.sxg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Exchanges) != len(in.Exchanges) {
+		t.Fatalf("%d exchanges are packed, want %d", len(out.Exchanges), len(in.Exchanges))
+	}
+	wantHeaders := bundleVariants(in)
+	for i, want := range in.Exchanges {
+		found := false
+		for _, got := range out.Exchanges {
+			if got.Request.URL.String() != want.Request.URL.String() || got.Request.Header.Get("Accept") != want.Request.Header.Get("Accept") {
+				continue
+			}
+			found = true
+			if got.Response.Status != want.Response.Status || !bytes.Equal(got.Response.Body, want.Response.Body) {
+				t.Errorf("%s: the response is %v", want.Request.URL, got.Response)
+			}
+			header := got.Response.Header.Clone()
+			if want.Request.URL.Path == "/b/index.html" {
+				// The parent has the preload links to the others.
+				if links := header["Link"]; len(links) != 3 || strings.Contains(strings.Join(links, ","), "allowed-alt-sxg") {
+					t.Errorf("links of the parent %q", links)
+				}
+				header.Del("Link")
+			}
+			if !reflect.DeepEqual(header, wantHeaders[i]) {
+				t.Errorf("%s: headers %v, want %v", want.Request.URL, header, wantHeaders[i])
+			}
+		}
+		if !found {
+			t.Errorf("%s (%q) is not packed", want.Request.URL, want.Request.Header.Get("Accept"))
+		}
+	}
+	if wantHeaders[3].Get(variantKeyParam) != "image/webp" || wantHeaders[3].Get(variantsParam) != "accept;image/jpeg;image/webp" {
+		t.Errorf("variants of the webp %v", wantHeaders[3])
+	}
+	// Only the allowed-alt-sxg links of the parent to the others are dropped.
+	if len(dropped) != len(in.Exchanges)-1 {
+		t.Errorf("dropped links %v", dropped)
+	}
+	for _, d := range dropped {
+		if d.URL != "https://"+testDomainName+"/b/index.html" || !strings.Contains(d.Link, `rel="allowed-alt-sxg"`) {
+			t.Errorf("dropped %s of %s", d.Link, d.URL)
+		}
+	}
+
+	// Unpacking the packed bundle and packing it again keeps the URLs, the
+	// headers and the variants.
+	wbn.Reset()
+	if _, err := out.WriteTo(&wbn); err != nil {
+		t.Fatal(err)
+	}
+	packed, err := bundle.Read(&wbn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = newTestServer(t, WithScenarios(), WithBundle(packed, BundleOptions{Name: "b.sxg"}))
+	out, _, err = s.PackBundle("b.sxg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wbn.Reset()
+	if _, err := out.WriteTo(&wbn); err != nil {
+		t.Fatal(err)
+	}
+	repacked, err := bundle.Read(&wbn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repacked.Exchanges) != len(packed.Exchanges) {
+		t.Fatalf("%d exchanges are repacked, want %d", len(repacked.Exchanges), len(packed.Exchanges))
+	}
+	for _, want := range packed.Exchanges {
+		found := false
+		for _, got := range repacked.Exchanges {
+			if got.Request.URL.String() != want.Request.URL.String() || !reflect.DeepEqual(got.Request.Header, want.Request.Header) {
+				continue
+			}
+			found = true
+			if got.Response.Status != want.Response.Status || !bytes.Equal(got.Response.Body, want.Response.Body) {
+				t.Errorf("%s: the repacked response is %v", want.Request.URL, got.Response)
+			}
+			if !reflect.DeepEqual(got.Response.Header, want.Response.Header) {
+				t.Errorf("%s: repacked headers %v, want %v", want.Request.URL, got.Response.Header, want.Response.Header)
+			}
+		}
+		if !found {
+			t.Errorf("%s (%v) is not repacked", want.Request.URL, want.Request.Header)
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package subsxg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/WICG/webpackage/go/signedexchange/version"
+)
+
+// WPTOptions are the options of ExportWPT.
+type WPTOptions struct {
+	// Dir is the directory which the files are written to.
+	Dir string
+	// Origin and AltOrigin are the origins which the identities sign for in
+	// the web-platform-tests, such as https://web-platform.test:8444 and
+	// https://www1.web-platform.test:8444.
+	Origin    string
+	AltOrigin string
+	// Path is the path of Dir in the web-platform-tests, such as
+	// /signed-exchange/sub-sxg.
+	Path string
+}
+
+// The host of the /sxg/ URLs which are built for the export. They are
+// rewritten to the resources of the tests.
+const wptExportHost = "sub-sxg.invalid"
+
+// The marker of the unsigned fallback contents, by which the tests tell the
+// fallbacks from the signed payloads.
+const wptFallbackMarker = "sub-sxg-fallback"
+
+// wptHelperScript is written to resources/sub-sxg.js.
+const wptHelperScript = `// The helpers of the tests exported from sub-sxg. The unsigned fallback
+// contents have markers which the signed payloads don't have, so that the
+// tests can tell whether the browser fell back to the content URLs.
+
+function subSXGPrefetch(url) {
+  return new Promise((resolve, reject) => {
+    const link = document.createElement('link');
+    link.rel = 'prefetch';
+    link.href = url;
+    link.onload = resolve;
+    link.onerror = () => reject(new Error('prefetch failed: ' + url));
+    document.head.appendChild(link);
+  });
+}
+
+// subSXGLoadFrame resolves with the frame of url once it is loaded. The
+// pages on the alt origin are cross-origin, so they post their results back,
+// which frame.result resolves with.
+function subSXGLoadFrame(t, url) {
+  return new Promise(resolve => {
+    const frame = document.createElement('iframe');
+    t.add_cleanup(() => frame.remove());
+    frame.result = new Promise(resolveResult => {
+      const onMessage = e => {
+        if (e.source !== frame.contentWindow)
+          return;
+        window.removeEventListener('message', onMessage);
+        resolveResult(e.data);
+      };
+      window.addEventListener('message', onMessage);
+      t.add_cleanup(() => window.removeEventListener('message', onMessage));
+    });
+    frame.onload = () => resolve(frame);
+    frame.src = url;
+    document.body.appendChild(frame);
+  });
+}
+
+function subSXGIsFallbackDocument(doc) {
+  const walker = doc.createTreeWalker(doc, NodeFilter.SHOW_COMMENT);
+  while (walker.nextNode()) {
+    if (walker.currentNode.data.trim() === '` + wptFallbackMarker + `')
+      return true;
+  }
+  return false;
+}
+
+
+function subSXGTest(test) {
+  promise_test(async t => {
+    await subSXGPrefetch(test.sxg);
+    const frame = await subSXGLoadFrame(t, test.sxg);
+    let doc = null;
+    try {
+      doc = frame.contentDocument;
+    } catch (e) {}
+    let result;
+    if (doc) {
+      result = {
+        fallback: subSXGIsFallbackDocument(doc),
+        fallbacks: frame.contentWindow.subSXGFallbacks || [],
+      };
+    } else {
+      result = await frame.result;
+    }
+    assert_equals(result.fallback, test.outcome === 'fallback',
+                  'fallback of the page');
+    for (const sub of test.subresources) {
+      assert_equals(result.fallbacks.includes(sub.url), sub.outcome === 'fallback',
+                    'fallback of ' + sub.url);
+    }
+  }, test.name + (test.description ? ': ' + test.description : ''));
+}
+`
+
+// wptResultScript is appended to the pages of the tests on the alt origin.
+// The tests can't look into the cross-origin frames, so the pages post
+// whether they and their scripts fell back.
+const wptResultScript = `
+<script>
+addEventListener('load', () => {
+  const walker = document.createTreeWalker(document, NodeFilter.SHOW_COMMENT);
+  let fallback = false;
+  while (walker.nextNode()) {
+    if (walker.currentNode.data.trim() === '` + wptFallbackMarker + `')
+      fallback = true;
+  }
+  parent.postMessage({fallback: fallback, fallbacks: self.subSXGFallbacks || []}, '*');
+});
+</script>
+`
+
+const wptTestTemplate = `<!DOCTYPE html>
+<meta charset="utf-8">
+<title>sub-sxg: %s</title>
+<script src="/resources/testharness.js"></script>
+<script src="/resources/testharnessreport.js"></script>
+<script src="resources/sub-sxg.js"></script>
+<body>
+<script>
+subSXGTest(%s);
+</script>
+</body>
+`
+
+// A wptSubresource is a subresource whose outcome the test asserts. Only the
+// scripts mark their fallback contents, and the other outcomes, such as CORB
+// and CORS blocking, leave nothing which the page can observe, so the other
+// subresources are left out.
+type wptSubresource struct {
+	Name    string  `json:"name"`
+	URL     string  `json:"url"`
+	Outcome Outcome `json:"outcome"`
+}
+
+type wptTest struct {
+	Name         string           `json:"name"`
+	Description  string           `json:"description,omitempty"`
+	SXG          string           `json:"sxg"`
+	ContentURL   string           `json:"content_url"`
+	Outcome      Outcome          `json:"outcome"`
+	Subresources []wptSubresource `json:"subresources"`
+}
+
+// exchangeRewriterKey is the context key of the function which rewrites the
+// params and the outer headers of the scenarios for the export.
+type exchangeRewriterKey struct{}
+
+type exchangeRewriter func(params *exchangeParams, outer http.Header)
+
+// wptRewriter rewrites the URLs of a scenario to the resources of its test:
+// the exchanges are at resources/<test>/sxg/, and the contents of the
+// identities are at resources/<test>/demo/ and resources/<test>/alt/.
+type wptRewriter struct {
+	s    *Server
+	opts WPTOptions
+	test string
+}
+
+func (w *wptRewriter) resources(origin string) string {
+	return origin + w.opts.Path + "/resources/" + w.test + "/"
+}
+
+func (w *wptRewriter) rewriteURL(u string) string {
+	for _, m := range []struct{ from, to string }{
+		{"https://" + wptExportHost + "/sxg/", w.resources(w.opts.Origin) + "sxg/"},
+		{"https://" + w.s.demoDomainName + "/", w.resources(w.opts.Origin) + "demo/"},
+		{"https://" + w.s.altDemoDomainName + "/", w.resources(w.opts.AltOrigin) + "alt/"},
+	} {
+		if strings.HasPrefix(u, m.from) {
+			rest := strings.TrimPrefix(u, m.from)
+			// The queries of the exchanges, such as the shapings, can't
+			// be served from the files.
+			if m.from == "https://"+wptExportHost+"/sxg/" {
+				rest = strings.SplitN(rest, "?", 2)[0]
+			}
+			return m.to + rest
+		}
+	}
+	return u
+}
+
+func (w *wptRewriter) rewriteLinks(values []string) []string {
+	links, err := parseLinks(values)
+	if err != nil {
+		return values
+	}
+	var rewritten []string
+	for _, l := range links {
+		l.target = w.rewriteURL(l.target)
+		for i, p := range l.params {
+			switch p.name {
+			case "anchor":
+				l.params[i].value = w.rewriteURL(p.value)
+			case "imagesrcset":
+				var candidates []string
+				for _, c := range strings.Split(p.value, ",") {
+					f := strings.Fields(c)
+					if len(f) > 0 {
+						f[0] = w.rewriteURL(f[0])
+					}
+					candidates = append(candidates, strings.Join(f, " "))
+				}
+				l.params[i].value = strings.Join(candidates, ", ")
+			}
+		}
+		rewritten = append(rewritten, l.String())
+	}
+	return rewritten
+}
+
+func (w *wptRewriter) rewrite(params *exchangeParams, outer http.Header) {
+	params.contentUrl = w.rewriteURL(params.contentUrl)
+	params.certUrl = w.opts.Origin + w.opts.Path + "/resources/cert/" + params.identity.name + ".cbor"
+	params.validityUrl = w.opts.Origin + w.opts.Path + "/resources/cert/null.validity.msg"
+	for _, m := range []struct{ from, to string }{
+		{"https://" + w.s.demoDomainName + "/", w.resources(w.opts.Origin) + "demo/"},
+		{"https://" + w.s.altDemoDomainName + "/", w.resources(w.opts.AltOrigin) + "alt/"},
+	} {
+		params.payload = bytes.ReplaceAll(params.payload, []byte(m.from), []byte(m.to))
+	}
+	if links := params.resHeader["Link"]; len(links) > 0 {
+		params.resHeader["Link"] = w.rewriteLinks(links)
+	}
+	if links := outer["Link"]; len(links) > 0 {
+		outer["Link"] = w.rewriteLinks(links)
+	}
+}
+
+// addResultScript appends wptResultScript to the payload of params when it
+// is an HTML page on the alt origin, which the test can't look into.
+func (w *wptRewriter) addResultScript(params *exchangeParams) {
+	mediaType, _, _ := mime.ParseMediaType(params.contentType)
+	if mediaType != "text/html" || !strings.HasPrefix(params.contentUrl, w.resources(w.opts.AltOrigin)) {
+		return
+	}
+	params.payload = append(params.payload[:len(params.payload):len(params.payload)], wptResultScript...)
+}
+
+// localPath returns the path in opts.Dir of the URL u in the tests.
+func (w *wptRewriter) localPath(u string) (string, bool) {
+	for _, origin := range []string{w.opts.Origin, w.opts.AltOrigin} {
+		if strings.HasPrefix(u, origin+w.opts.Path+"/") {
+			p, err := url.PathUnescape(strings.TrimPrefix(u, origin+w.opts.Path+"/"))
+			if err != nil {
+				return "", false
+			}
+			return filepath.Join(w.opts.Dir, filepath.FromSlash(path.Clean("/"+p))), true
+		}
+	}
+	return "", false
+}
+
+func writeWPTFile(name string, body []byte, header http.Header) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name, body, 0644); err != nil {
+		return err
+	}
+	var headers bytes.Buffer
+	for _, name := range []string{"Content-Type", "X-Content-Type-Options", "Access-Control-Allow-Origin", "Cache-Control", "Link"} {
+		for _, v := range header[name] {
+			fmt.Fprintf(&headers, "%s: %s\n", name, v)
+		}
+	}
+	return ioutil.WriteFile(name+".headers", headers.Bytes(), 0644)
+}
+
+// fallbackPayload returns the payload of the unsigned content at the content
+// URL of params, which has the marker for the tests.
+func fallbackPayload(params *exchangeParams) ([]byte, string) {
+	payload := append([]byte{}, params.payload...)
+	mediaType, _, _ := mime.ParseMediaType(params.contentType)
+	switch mediaType {
+	case "text/html":
+		return append(payload, "\n<!-- "+wptFallbackMarker+" -->\n"...), "document"
+	case "text/javascript", "application/javascript":
+		u, _ := json.Marshal(params.contentUrl)
+		return append(payload, "\n;(self.subSXGFallbacks = self.subSXGFallbacks || []).push("+string(u)+");\n"...), "script"
+	}
+	return payload, ""
+}
+
+var errWPTSkipped = errors.New("the outer response is not a signed exchange")
+
+// exportExchange writes the exchange of the scenario name, its fallback
+// content and the exchanges of its alternate links, and returns the params.
+// It returns errWPTSkipped when any of them responds with a redirect.
+func (s *Server) exportExchange(w *wptRewriter, name string, exported map[string]*exchangeParams) (*exchangeParams, error) {
+	if params, ok := exported[name]; ok {
+		return params, nil
+	}
+	req := httptest.NewRequest(http.MethodGet, "https://"+wptExportHost+"/sxg/"+name, nil)
+	req = req.WithContext(context.WithValue(req.Context(), exchangeRewriterKey{}, exchangeRewriter(w.rewrite)))
+	req.Header.Set("Accept", sxgContentType(version.Version1b3))
+	params, err := s.newExchangeParams(req)
+	if err != nil || params.redirectTo != "" {
+		return nil, errWPTSkipped
+	}
+	exported[name] = params
+	// Only the page of the test is loaded in a frame. The alternate
+	// exchanges keep their payloads, which their parents' header-integrity
+	// values are derived from.
+	if name == w.test+".sxg" {
+		w.addResultScript(params)
+	}
+
+	e, err := createExchange(params)
+	if err != nil {
+		return nil, err
+	}
+	var body bytes.Buffer
+	if err := e.Write(&body); err != nil {
+		return nil, err
+	}
+	outer := params.outerHeader.Clone()
+	outer.Set("Content-Type", sxgContentType(params.ver))
+	outer.Set("X-Content-Type-Options", "nosniff")
+	sxgPath, _ := w.localPath(w.rewriteURL("https://" + wptExportHost + "/sxg/" + name))
+	if err := writeWPTFile(sxgPath, body.Bytes(), outer); err != nil {
+		return nil, err
+	}
+
+	if contentPath, ok := w.localPath(params.contentUrl); ok {
+		// The variants share the content URL. The first one is served.
+		if _, err := os.Stat(contentPath); os.IsNotExist(err) {
+			payload, _ := fallbackPayload(params)
+			header := params.resHeader.Clone()
+			header.Set("Content-Type", params.contentType)
+			header.Del("Link")
+			if err := writeWPTFile(contentPath, payload, header); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	links, _ := parseLinks(params.outerHeader["Link"])
+	for _, l := range links {
+		if !l.hasRel("alternate") {
+			continue
+		}
+		child := strings.TrimPrefix(l.target, w.resources(w.opts.Origin)+"sxg/")
+		if child == l.target {
+			continue
+		}
+		if _, err := s.exportExchange(w, child, exported); err == errWPTSkipped {
+			return nil, err
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %v", child, err)
+		}
+	}
+	return params, nil
+}
+
+// exportWPTTest writes the test of sc, and the resources of its exchanges.
+func (s *Server) exportWPTTest(opts WPTOptions, sc *Scenario) error {
+	w := &wptRewriter{s: s, opts: opts, test: strings.TrimSuffix(sc.Name, ".sxg")}
+	exported := map[string]*exchangeParams{}
+	params, err := s.exportExchange(w, sc.Name, exported)
+	if err != nil {
+		return err
+	}
+
+	test := wptTest{
+		Name:         sc.Name,
+		Description:  sc.Expected,
+		SXG:          w.rewriteURL("https://" + wptExportHost + "/sxg/" + sc.Name),
+		ContentURL:   params.contentUrl,
+		Outcome:      sc.outcome(),
+		Subresources: []wptSubresource{},
+	}
+	for _, name := range sc.subresourceNames() {
+		outcome := sc.Subresources[name]
+		p, ok := exported[name]
+		if !ok || (outcome != OutcomeSXG && outcome != OutcomeFallback) {
+			continue
+		}
+		if _, marker := fallbackPayload(p); marker != "script" {
+			continue
+		}
+		test.Subresources = append(test.Subresources, wptSubresource{Name: name, URL: p.contentUrl, Outcome: outcome})
+	}
+	j, err := json.MarshalIndent(test, "", "  ")
+	if err != nil {
+		return err
+	}
+	html := fmt.Sprintf(wptTestTemplate, sc.Name, j)
+	return ioutil.WriteFile(filepath.Join(opts.Dir, w.test+".https.html"), []byte(html), 0644)
+}
+
+// ExportWPT writes a web-platform-tests style test for each listed scenario
+// to opts.Dir, along with the signed exchanges, the cert-chains and the
+// fallback contents which it loads. The shaped scenarios and the scenarios
+// which respond with redirects are skipped, and their names are returned.
+// The tests assert the fallback of the pages and of their scripts; the other
+// outcomes of the subresources are left to the index page.
+func (s *Server) ExportWPT(opts WPTOptions) ([]string, error) {
+	if err := os.MkdirAll(filepath.Join(opts.Dir, "resources", "cert"), 0755); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(opts.Dir, "resources", "sub-sxg.js"), []byte(wptHelperScript), 0644); err != nil {
+		return nil, err
+	}
+	for _, id := range []*Identity{s.defaultIdentity, s.altIdentity} {
+		msg := id.getCertMessage()
+		if msg == nil {
+			return nil, fmt.Errorf("no cert-chain of %s", id.name)
+		}
+		if err := writeWPTFile(filepath.Join(opts.Dir, "resources", "cert", id.name+".cbor"), msg, http.Header{"Content-Type": {"application/cert-chain+cbor"}}); err != nil {
+			return nil, err
+		}
+	}
+
+	var skipped []string
+	for i := range s.scenarios {
+		sc := &s.scenarios[i]
+		if !sc.Listed {
+			continue
+		}
+		if !sc.Shaping.isZero() || !sc.SubresourceShaping.isZero() || !sc.CertShaping.isZero() {
+			skipped = append(skipped, sc.Name)
+			continue
+		}
+		err := s.exportWPTTest(opts, sc)
+		if err == errWPTSkipped {
+			skipped = append(skipped, sc.Name)
+			continue
+		}
+		if err != nil {
+			return skipped, fmt.Errorf("%s: %v", sc.Name, err)
+		}
+	}
+	return skipped, nil
+}
@@ -0,0 +1,194 @@
+package subsxg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/WICG/webpackage/go/signedexchange"
+)
+
+func TestExportWPT(t *testing.T) {
+	s := newTestServer(t)
+	opts := WPTOptions{
+		Dir:       t.TempDir(),
+		Origin:    "https://" + testDomainName,
+		AltOrigin: "https://" + testAltDomainName,
+		Path:      "/signed-exchange/sub-sxg",
+	}
+	if _, err := s.ExportWPT(opts); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.Dir, "resources", "sub-sxg.js")); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.Dir, "amptestnocdn_js_img_preload.https.html")); err != nil {
+		t.Error(err)
+	}
+
+	// The tests assert the outcomes which the pages can observe: the
+	// fallback of the page and of its scripts.
+	for name, want := range map[string][]wptSubresource{
+		"amptestnocdn_js_img_preload":   {{Name: "v0.sxg", URL: opts.Origin + opts.Path + "/resources/amptestnocdn_js_img_preload/demo/amptest/js/v0.js", Outcome: OutcomeSXG}},
+		"amptestnocdn_js_preload_error": {{Name: "v0.sxg", URL: opts.Origin + opts.Path + "/resources/amptestnocdn_js_preload_error/demo/amptest/js/v0.js", Outcome: OutcomeFallback}},
+		"corbtest":                      {},
+		"fonttest":                      {},
+	} {
+		test := readWPTTest(t, opts.Dir, name)
+		if !reflect.DeepEqual(test.Subresources, want) {
+			t.Errorf("%s: subresources %+v, want %+v", name, test.Subresources, want)
+		}
+	}
+	tests, err := filepath.Glob(filepath.Join(opts.Dir, "*.https.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range tests {
+		test := readWPTTest(t, opts.Dir, strings.TrimSuffix(filepath.Base(file), ".https.html"))
+		if test.Outcome != OutcomeSXG && test.Outcome != OutcomeFallback {
+			t.Errorf("%s: the outcome %s can't be asserted", file, test.Outcome)
+		}
+		for _, sub := range test.Subresources {
+			if sub.Outcome != OutcomeSXG && sub.Outcome != OutcomeFallback {
+				t.Errorf("%s: the outcome %s of %s can't be asserted", file, sub.Outcome, sub.Name)
+			}
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(opts.Dir, "resources", "*", "sxg", "*.sxg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no exchanges are exported")
+	}
+	exchanges := map[string]*signedexchange.Exchange{}
+	children := map[string]*signedexchange.Exchange{}
+	integrities := map[string]string{}
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e, err := signedexchange.ReadExchange(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if !strings.HasPrefix(e.RequestURI, opts.Origin+opts.Path+"/resources/") && !strings.HasPrefix(e.RequestURI, opts.AltOrigin+opts.Path+"/resources/") {
+			t.Errorf("%s: the content URL %s is not rewritten", file, e.RequestURI)
+		}
+		exchanges[file] = e
+		key := e.RequestURI + " " + e.ResponseHeaders.Get(variantKeyParam)
+		children[key] = e
+		integrities[key] = headerIntegrityOf(t, e)
+	}
+
+	// The allowed-alt-sxg links must match the exported children, except in
+	// the scenarios which break them on purpose. In the cycle of a_css.sxg
+	// and b_css.sxg, each links to the other as it is with the link back
+	// closing the cycle.
+	for file, e := range exchanges {
+		test := filepath.Base(filepath.Dir(filepath.Dir(file)))
+		if strings.HasSuffix(test, "_error") {
+			continue
+		}
+		cyclic := test == "loop" && (filepath.Base(file) == "a_css.sxg" || filepath.Base(file) == "b_css.sxg")
+		links, err := parseLinks(e.ResponseHeaders["Link"])
+		if err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		for _, l := range links {
+			if !l.hasRel("allowed-alt-sxg") {
+				continue
+			}
+			key := l.target + " " + l.get(variantKeyParam)
+			want, ok := integrities[key]
+			if !ok {
+				continue
+			}
+			if cyclic {
+				want = closedCycleIntegrity(t, children[key], e.RequestURI)
+			}
+			if got := l.get("header-integrity"); got != want {
+				t.Errorf("%s: header-integrity of %s is %s, want %s", file, l.target, got, want)
+			}
+		}
+	}
+}
+
+// readWPTTest returns the test which the exported test of name runs.
+func readWPTTest(t *testing.T, dir string, name string) *wptTest {
+	t.Helper()
+	html, err := ioutil.ReadFile(filepath.Join(dir, name+".https.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := bytes.Index(html, []byte("subSXGTest("))
+	end := bytes.LastIndex(html, []byte(");\n</script>"))
+	if start < 0 || end < start {
+		t.Fatalf("%s: no test in\n%s", name, html)
+	}
+	test := &wptTest{}
+	if err := json.Unmarshal(html[start+len("subSXGTest("):end], test); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return test
+}
+
+// closedCycleIntegrity returns the header-integrity of child as parent derives
+// it, when child links back to parent: the link back has
+// cyclicHeaderIntegrity.
+func closedCycleIntegrity(t *testing.T, child *signedexchange.Exchange, parent string) string {
+	t.Helper()
+	links, err := parseLinks(child.ResponseHeaders["Link"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := child.ResponseHeaders.Clone()
+	header.Del("Link")
+	for _, l := range links {
+		if l.hasRel("allowed-alt-sxg") && l.target == parent {
+			for i := range l.params {
+				if l.params[i].name == "header-integrity" {
+					l.params[i].value = cyclicHeaderIntegrity
+				}
+			}
+		}
+		header.Add("Link", l.String())
+	}
+	closed := *child
+	closed.ResponseHeaders = header
+	return headerIntegrityOf(t, &closed)
+}
+
+// The test can't look into the pages on the alt origin, so they post their
+// results back.
+func TestExportWPTCrossOriginPage(t *testing.T) {
+	a, err := ReadArchive(bytes.NewReader(testHAR(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, WithScenarios(), WithArchive(a, ArchiveOptions{Alt: true}))
+	opts := WPTOptions{
+		Dir:       t.TempDir(),
+		Origin:    "https://" + testDomainName,
+		AltOrigin: "https://" + testAltDomainName,
+		Path:      "/signed-exchange/sub-sxg",
+	}
+	if _, err := s.ExportWPT(opts); err != nil {
+		t.Fatal(err)
+	}
+	page, err := ioutil.ReadFile(filepath.Join(opts.Dir, "resources", "archive_www_example_com", "alt", "imported", "archive_www_example_com", "www.example.com", "page", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(page), "parent.postMessage(") {
+		t.Errorf("the page doesn't post its result:\n%s", page)
+	}
+}
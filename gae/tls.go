@@ -0,0 +1,116 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In the local TLS mode, the server certificate for each host is loaded from
+// tlsCertDir/<host>.pem and tlsCertDir/<host>.key. If they don't exist, a
+// certificate is issued by the development CA in devCAPemFileName and
+// devCAKeyFileName, which must be trusted by the browser.
+var (
+	tlsCertDir       = "cert/tls"
+	devCAPemFileName = "cert/dev_ca.pem"
+	devCAKeyFileName = "cert/dev_ca.key"
+)
+
+const devCertLifetime = 30 * 24 * time.Hour
+
+type tlsCertificates struct {
+	defaultHost string
+
+	mu    sync.Mutex
+	certs map[string]*tls.Certificate
+	devCA *tls.Certificate
+}
+
+func newTLSCertificates(defaultHost string) *tlsCertificates {
+	c := &tlsCertificates{defaultHost: defaultHost, certs: map[string]*tls.Certificate{}}
+	if ca, err := tls.LoadX509KeyPair(devCAPemFileName, devCAKeyFileName); err == nil {
+		c.devCA = &ca
+	}
+	return c
+}
+
+// getCertificate picks the certificate for the host name in the SNI.
+func (c *tlsCertificates) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	host := strings.ToLower(hello.ServerName)
+	if host == "" {
+		host = c.defaultHost
+	}
+	if strings.ContainsAny(host, `/\`) {
+		return nil, errors.New("invalid server name")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cert, ok := c.certs[host]; ok && (cert.Leaf == nil || time.Now().Before(cert.Leaf.NotAfter)) {
+		return cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(filepath.Join(tlsCertDir, host+".pem"), filepath.Join(tlsCertDir, host+".key"))
+	if err != nil {
+		if c.devCA == nil {
+			return nil, errors.New("no certificate for " + host)
+		}
+		if cert, err = issueDevCertificate(c.devCA, host); err != nil {
+			return nil, err
+		}
+	}
+	c.certs[host] = &cert
+	return &cert, nil
+}
+
+func issueDevCertificate(ca *tls.Certificate, host string) (tls.Certificate, error) {
+	caCert, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(devCertLifetime),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	} else {
+		template.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, caCert, key.Public(), ca.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der, ca.Certificate[0]},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}